@@ -0,0 +1,75 @@
+package fncmp
+
+import (
+	"sync/atomic"
+)
+
+// App holds the state of an fncmp application: its configuration, handlers,
+// connections, caches, subscriptions and lifecycle functions. Apps share
+// nothing, so several can run in one process, e.g. one per test.
+//
+// The package-level functions, such as MiddleWareFn and Shutdown, use a
+// default App configured with SetConfig. NewCache, UseCache, Subscribe and
+// the other functions taking a context use the App serving the context's
+// connection.
+type App struct {
+	config          *Config
+	connPool        conns
+	handlers        handlerPool
+	evtListeners    eventListeners
+	sm              storeManager
+	onfns           _onfns
+	outboxes        outboxPool
+	topics          topicPool
+	remote          remoteSubs
+	lifecycle       lifecycleFns
+	middleware      middlewares
+	scheduler       scheduler
+	goroutines      goroutineCounter
+	shuttingDown    atomic.Bool  // Set by Shutdown to stop accepting new connections
+	inflight        atomic.Int64 // Dispatches queued for or being handled by a handler
+	droppedMessages atomic.Uint64
+	droppedEvents   atomic.Uint64
+}
+
+// defaultApp is used by the package-level functions
+var defaultApp = NewApp(nil)
+
+// NewApp returns an App configured with c, or with the default
+// configuration if c is nil
+func NewApp(c *Config) *App {
+	a := &App{
+		connPool:     conns{pool: make(map[string]*conn)},
+		handlers:     handlerPool{pool: make(map[string]handler)},
+		evtListeners: eventListeners{el: make(map[string]map[string]EventListener)},
+		sm:           storeManager{stores: make(map[interface{}]*store)},
+		onfns: _onfns{
+			onchange:  make(map[string]any),
+			ontimeout: make(map[string]any),
+			history:   make(map[string]map[string]any),
+		},
+		topics: topicPool{subs: make(map[string]map[string]struct{})},
+	}
+	a.outboxes = outboxPool{app: a, pool: make(map[string]*outbox)}
+	a.remote = remoteSubs{app: a, unsub: make(map[string]func())}
+	a.scheduler = scheduler{app: a, wake: make(chan struct{}, 1)}
+	if c == nil {
+		a.config = defaultConfig()
+	} else {
+		a.SetConfig(c)
+	}
+	return a
+}
+
+// Config returns the configuration of the App
+func (a *App) Config() *Config {
+	return a.config
+}
+
+// orDefault returns a, or the default App if a is nil
+func orDefault(a *App) *App {
+	if a == nil {
+		return defaultApp
+	}
+	return a
+}
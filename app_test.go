@@ -0,0 +1,67 @@
+package fncmp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestApp(t *testing.T) {
+	a, b := NewApp(&Config{Silent: true}), NewApp(&Config{Silent: true})
+
+	// Apps have their own cache stores
+	for _, app := range []*App{a, b} {
+		ctx := (&conn{app: app, ID: t.Name()}).withContext(context.Background())
+		if _, err := NewCache(ctx, "key", app == a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := (&conn{app: a, ID: t.Name()}).withContext(context.Background())
+	if c, err := UseCache[bool](ctx, "key"); err != nil || !c.Value() {
+		t.Errorf("expected cache of a, got %v, %v", c.Value(), err)
+	}
+
+	// Apps have their own connections and shut down independently
+	dial := func(app *App) *websocket.Conn {
+		t.Helper()
+		server := httptest.NewServer(app.MiddleWareFn(
+			func(w http.ResponseWriter, r *http.Request) {},
+			func(ctx context.Context) FnComponent { return NewFn(ctx, HTML("<p>app</p>")) },
+		))
+		t.Cleanup(server.Close)
+		ws := _test_dial(t, _test_url(app, server, "/", t.Name()), nil)
+		_test_read(t, ws, render)
+		return ws
+	}
+	dial(a)
+	ws := dial(b)
+
+	// Tokens are signed with each App's own key
+	if _, err := b.verifyConnToken(a.signConnToken("id", time.Now().Add(time.Minute))); err == nil {
+		t.Error("expected token of a to be rejected by b")
+	}
+	if len(a.Connections()) != 1 || len(b.Connections()) != 1 {
+		t.Fatalf("expected one connection each, got %d and %d", len(a.Connections()), len(b.Connections()))
+	}
+	if _, ok := Connection(t.Name()); ok {
+		t.Error("expected no connection in the default App")
+	}
+
+	if err := a.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Connections()) != 0 {
+		t.Errorf("expected a to have no connections, got %d", len(a.Connections()))
+	}
+	if _, ok := b.Connection(t.Name()); !ok {
+		t.Error("expected b to keep its connection")
+	}
+	ws.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, _, err := ws.ReadMessage(); websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Error("expected b's connection to stay open")
+	}
+}
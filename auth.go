@@ -0,0 +1,87 @@
+package fncmp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Authenticator checks the token a client presents when it connects and
+// returns the principal it identifies, e.g. a user.
+//
+// The principal is available to every HandleFn and event listener of the
+// connection through Principal.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (principal any, err error)
+}
+
+// AuthenticatorFunc adapts a function to an Authenticator
+type AuthenticatorFunc func(ctx context.Context, token string) (any, error)
+
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, token string) (any, error) {
+	return f(ctx, token)
+}
+
+// Principal returns the principal Config.Authenticator returned for the
+// connection, or false if the connection is not authenticated
+func Principal(ctx context.Context) (any, bool) {
+	p := ctx.Value(PrincipalKey)
+	return p, p != nil
+}
+
+// handshakeToken returns the token sent with the websocket handshake, either
+// as the fncmp_auth query parameter or as a bearer Authorization header
+func handshakeToken(r *http.Request) (string, bool) {
+	if token := r.URL.Query().Get("fncmp_auth"); token != "" {
+		return token, true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token, ok && token != ""
+}
+
+// authenticate checks token with Config.Authenticator
+func (a *App) authenticate(ctx context.Context, token string) (any, error) {
+	if token == "" {
+		return nil, fmt.Errorf("%w: no token", ErrUnauthorized)
+	}
+	principal, err := a.config.Authenticator.Authenticate(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+	if principal == nil {
+		return nil, fmt.Errorf("%w: no principal", ErrUnauthorized)
+	}
+	return principal, nil
+}
+
+// awaitAuth reads the token from the first dispatch of the connection, which
+// must be an auth dispatch sent within Config.AuthTimeout
+func (c *conn) awaitAuth() (string, error) {
+	config := c.app.config
+	c.websocket.SetReadLimit(config.MaxMessageSize)
+	c.websocket.SetReadDeadline(time.Now().Add(config.AuthTimeout))
+	_, msg, err := c.websocket.ReadMessage()
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+	var d Dispatch
+	if err := decodeDispatch(c.codec, config.MaxEventDataDepth, msg, &d); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+	if d.Function != auth {
+		return "", fmt.Errorf("%w: expected auth, got '%s'", ErrUnauthorized, d.Function)
+	}
+	return d.FnAuth.Token, nil
+}
+
+// reject closes a connection that was never admitted to the pool with a
+// policy violation
+func (c *conn) reject(reason string) {
+	msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	c.websocket.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	c.websocket.Close()
+}
@@ -0,0 +1,88 @@
+package fncmp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestAuthenticator(t *testing.T) {
+	authenticator, unauthenticated := defaultApp.config.Authenticator, defaultApp.config.UnauthenticatedHandler
+	defer func() {
+		defaultApp.config.Authenticator, defaultApp.config.UnauthenticatedHandler = authenticator, unauthenticated
+	}()
+	defaultApp.config.Authenticator = AuthenticatorFunc(func(ctx context.Context, token string) (any, error) {
+		if token != "secret" {
+			return nil, errors.New("invalid token")
+		}
+		return "alice", nil
+	})
+
+	server := _test_server(func(ctx context.Context) FnComponent {
+		principal, _ := Principal(ctx)
+		return NewFn(ctx, HTML("<p>"+principal.(string)+"</p>"))
+	})
+	defer server.Close()
+
+	cases := []struct {
+		name       string
+		query      string
+		header     http.Header
+		auth       string
+		restricted HandleFn
+		exp        string
+		status     int
+	}{
+		{name: "query token", query: "&fncmp_auth=secret", exp: "<p>alice</p>"},
+		{name: "bearer token", header: http.Header{"Authorization": {"Bearer secret"}}, exp: "<p>alice</p>"},
+		{name: "invalid token", query: "&fncmp_auth=wrong", status: http.StatusUnauthorized},
+		{name: "auth dispatch", auth: "secret", exp: "<p>alice</p>"},
+		{name: "invalid auth dispatch", auth: "wrong"},
+		{
+			name:  "restricted",
+			query: "&fncmp_auth=wrong",
+			restricted: func(ctx context.Context) FnComponent {
+				if _, ok := Principal(ctx); ok {
+					t.Error("expected no principal")
+				}
+				return NewFn(ctx, HTML("<p>sign in</p>"))
+			},
+			exp: "<p>sign in</p>",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defaultApp.config.UnauthenticatedHandler = c.restricted
+			ws, resp, err := websocket.DefaultDialer.Dial(_test_url(defaultApp, server, "/", t.Name())+c.query, c.header)
+			if c.status != 0 {
+				if err == nil || resp.StatusCode != c.status {
+					t.Fatalf("expected status %d, got %v", c.status, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ws.Close()
+			if c.auth != "" {
+				ws.WriteJSON(Dispatch{Function: auth, FnAuth: FnAuth{Token: c.auth}})
+			}
+			if c.exp == "" {
+				// Rejected after the handshake
+				_, _, err := ws.ReadMessage()
+				if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+					t.Fatalf("expected policy violation, got %v", err)
+				}
+				return
+			}
+			if d := _test_read(t, ws, render); !strings.Contains(d.FnRender.HTML, c.exp) {
+				t.Errorf("expected %s, got %s", c.exp, d.FnRender.HTML)
+			}
+		})
+	}
+}
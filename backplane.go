@@ -0,0 +1,226 @@
+package fncmp
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// Backplane carries encoded dispatches between processes, so a dispatch can
+// reach a connection or topic subscriber no matter which process holds the
+// socket.
+//
+// Channels are named "conn:<connection ID>" or "topic:<topic>".
+type Backplane interface {
+	// Publish sends msg to every subscriber of channel in every process
+	Publish(ctx context.Context, channel string, msg []byte) error
+	// Subscribe calls fn with each message published to channel until the
+	// returned unsubscribe function is called
+	Subscribe(channel string, fn func(msg []byte)) (unsubscribe func(), err error)
+	Close() error
+}
+
+func connChannel(id string) string {
+	return "conn:" + id
+}
+
+func topicChannel(topic string) string {
+	return "topic:" + topic
+}
+
+// DispatchTo sends fn with the default App. See App.DispatchTo.
+func DispatchTo(ctx context.Context, connID string, fn FnComponent) error {
+	return defaultApp.DispatchTo(ctx, connID, fn)
+}
+
+// DispatchTo sends fn to the connection with connID through Config.Backplane,
+// whichever process holds the connection.
+//
+// Event listeners are not carried across processes; fn should only render,
+// change classes, redirect or run JavaScript.
+func (a *App) DispatchTo(ctx context.Context, connID string, fn FnComponent) error {
+	msg, ok, err := encodeRemote(fn)
+	if !ok || err != nil {
+		return err
+	}
+	return a.config.Backplane.Publish(ctx, connChannel(connID), msg)
+}
+
+// Broadcast sends fn with the default App. See App.Broadcast.
+func Broadcast(ctx context.Context, topic string, fn FnComponent) error {
+	return defaultApp.Broadcast(ctx, topic, fn)
+}
+
+// Broadcast sends fn to every subscriber of topic in every process through
+// Config.Backplane.
+//
+// Unlike Publish, fn is rendered once and event listeners are not carried.
+func (a *App) Broadcast(ctx context.Context, topic string, fn FnComponent) error {
+	msg, ok, err := encodeRemote(fn)
+	if !ok || err != nil {
+		return err
+	}
+	return a.config.Backplane.Publish(ctx, topicChannel(topic), msg)
+}
+
+// encodeRemote renders fn and encodes its dispatch for the backplane.
+//
+// It returns false if there is nothing to send.
+func encodeRemote(fn FnComponent) ([]byte, bool, error) {
+	d := *fn.dispatch
+	switch d.Function {
+	case render:
+		d.FnRender.EventListeners = nil
+		if len(d.buf) == 0 && d.FnRender.HTML == "" && !d.FnRender.Remove {
+			return nil, false, nil
+		}
+		var data Writer
+		FnComponent{Context: fn.Context, dispatch: &d, id: fn.id}.Render(context.Background(), &data)
+		d.FnRender.HTML = sanitizeHTML(string(data.buf))
+	case class:
+		if len(d.FnClass.Names) == 0 {
+			return nil, false, nil
+		}
+	case redirect:
+		if d.FnRedirect.URL == "" {
+			return nil, false, nil
+		}
+	case custom:
+		if d.FnCustom.Function == "" {
+			return nil, false, nil
+		}
+	default:
+		return nil, false, nil
+	}
+	b, err := json.Marshal(d)
+	return b, err == nil, err
+}
+
+// deliverRemote decodes a dispatch from the backplane and publishes it to
+// each local connection ID in ids
+func (a *App) deliverRemote(msg []byte, ids ...string) {
+	for _, id := range ids {
+		var d Dispatch
+		if err := json.Unmarshal(msg, &d); err != nil {
+			a.config.Logger.Error("failed to decode backplane dispatch", "error", err)
+			return
+		}
+		d.ConnID = id
+		d.HandlerID = ""
+		// A connection whose page rendered no HTML has no outbox yet
+		var ob *outbox
+		if c, ok := a.connPool.Get(id); ok {
+			d.HandlerID = c.HandlerID
+			ob = a.outboxes.GetOrCreate(id)
+		} else if ob, ok = a.outboxes.Get(id); !ok {
+			continue
+		}
+		if err := ob.publish(d); err != nil {
+			a.config.Logger.Error("failed to deliver backplane dispatch", "conn_id", id, "error", err)
+		}
+	}
+}
+
+// remoteSubs tracks an App's backplane subscriptions
+type remoteSubs struct {
+	mu    sync.Mutex
+	app   *App
+	unsub map[string]func()
+}
+
+// Subscribe subscribes to channel on Config.Backplane unless already
+// subscribed
+func (r *remoteSubs) Subscribe(channel string, fn func(msg []byte)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	config := r.app.config
+	if _, ok := r.unsub[channel]; ok || config.Backplane == nil {
+		return
+	}
+	unsub, err := config.Backplane.Subscribe(channel, fn)
+	if err != nil {
+		config.Logger.Error("failed to subscribe to backplane", "channel", channel, "error", err)
+		return
+	}
+	r.unsub[channel] = unsub
+}
+
+func (r *remoteSubs) Unsubscribe(channel string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if unsub, ok := r.unsub[channel]; ok {
+		unsub()
+		delete(r.unsub, channel)
+	}
+}
+
+// subscribeConn receives backplane dispatches for connection id
+func (a *App) subscribeConn(id string) {
+	a.remote.Subscribe(connChannel(id), func(msg []byte) {
+		a.deliverRemote(msg, id)
+	})
+}
+
+// subscribeTopic receives backplane dispatches for local subscribers of topic
+func (a *App) subscribeTopic(topic string) {
+	a.remote.Subscribe(topicChannel(topic), func(msg []byte) {
+		a.deliverRemote(msg, a.topics.Get(topic)...)
+	})
+}
+
+// MemoryBackplane is a Backplane for a single process
+type MemoryBackplane struct {
+	mu     sync.Mutex
+	nextID int
+	subs   map[string]map[int]func(msg []byte)
+}
+
+// NewMemoryBackplane returns a Backplane that delivers messages within the
+// process. It is the default Config.Backplane.
+func NewMemoryBackplane() *MemoryBackplane {
+	return &MemoryBackplane{
+		subs: make(map[string]map[int]func(msg []byte)),
+	}
+}
+
+func (m *MemoryBackplane) Publish(ctx context.Context, channel string, msg []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	fns := make([]func(msg []byte), 0, len(m.subs[channel]))
+	for _, fn := range m.subs[channel] {
+		fns = append(fns, fn)
+	}
+	m.mu.Unlock()
+	for _, fn := range fns {
+		fn(msg)
+	}
+	return nil
+}
+
+func (m *MemoryBackplane) Subscribe(channel string, fn func(msg []byte)) (func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.subs[channel]; !ok {
+		m.subs[channel] = make(map[int]func(msg []byte))
+	}
+	m.nextID++
+	id := m.nextID
+	m.subs[channel][id] = fn
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.subs[channel], id)
+		if len(m.subs[channel]) == 0 {
+			delete(m.subs, channel)
+		}
+	}, nil
+}
+
+func (m *MemoryBackplane) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subs = make(map[string]map[int]func(msg []byte))
+	return nil
+}
@@ -0,0 +1,312 @@
+package fncmp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// backplaneFrame is the wire format between SocketBackplane and BackplaneHub,
+// sent as newline delimited JSON
+type backplaneFrame struct {
+	Op      string `json:"op"` // "sub", "unsub" or "pub"
+	Channel string `json:"channel"`
+	Data    []byte `json:"data,omitempty"`
+}
+
+// BackplaneHub relays messages between SocketBackplane clients over TCP or a
+// unix socket.
+//
+// It is intended for tests and small deployments where one process, or a
+// sidecar, runs the hub and every process dials it.
+type BackplaneHub struct {
+	app      *App
+	listener net.Listener
+	mu       sync.Mutex
+	clients  map[*hubClient]struct{}
+	subs     map[string]map[*hubClient]struct{}
+	wg       sync.WaitGroup
+}
+
+type hubClient struct {
+	conn net.Conn
+	mu   sync.Mutex
+	enc  *json.Encoder
+}
+
+func (c *hubClient) write(f backplaneFrame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enc.Encode(f)
+}
+
+// ListenBackplane starts a BackplaneHub logging through the default App. See
+// App.ListenBackplane.
+func ListenBackplane(network, address string) (*BackplaneHub, error) {
+	return defaultApp.ListenBackplane(network, address)
+}
+
+// ListenBackplane starts a BackplaneHub on the network address, e.g.
+// ("tcp", "127.0.0.1:7070") or ("unix", "/tmp/fncmp.sock"), logging through
+// the App's Config.Logger
+func (a *App) ListenBackplane(network, address string) (*BackplaneHub, error) {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	h := &BackplaneHub{
+		app:      a,
+		listener: l,
+		clients:  make(map[*hubClient]struct{}),
+		subs:     make(map[string]map[*hubClient]struct{}),
+	}
+	h.wg.Add(1)
+	go h.serve()
+	return h, nil
+}
+
+// Addr returns the address the hub is listening on
+func (h *BackplaneHub) Addr() net.Addr {
+	return h.listener.Addr()
+}
+
+// Close stops the hub and disconnects every client
+func (h *BackplaneHub) Close() error {
+	err := h.listener.Close()
+	h.mu.Lock()
+	for c := range h.clients {
+		c.conn.Close()
+	}
+	h.mu.Unlock()
+	h.wg.Wait()
+	return err
+}
+
+func (h *BackplaneHub) serve() {
+	defer h.wg.Done()
+	for {
+		conn, err := h.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &hubClient{conn: conn, enc: json.NewEncoder(conn)}
+		h.mu.Lock()
+		h.clients[c] = struct{}{}
+		h.mu.Unlock()
+		h.wg.Add(1)
+		go h.handle(c)
+	}
+}
+
+func (h *BackplaneHub) handle(c *hubClient) {
+	defer h.wg.Done()
+	defer func() {
+		h.mu.Lock()
+		delete(h.clients, c)
+		for channel, subs := range h.subs {
+			delete(subs, c)
+			if len(subs) == 0 {
+				delete(h.subs, channel)
+			}
+		}
+		h.mu.Unlock()
+		c.conn.Close()
+	}()
+
+	dec := json.NewDecoder(c.conn)
+	for {
+		var f backplaneFrame
+		if err := dec.Decode(&f); err != nil {
+			return
+		}
+		switch f.Op {
+		case "sub":
+			h.mu.Lock()
+			if _, ok := h.subs[f.Channel]; !ok {
+				h.subs[f.Channel] = make(map[*hubClient]struct{})
+			}
+			h.subs[f.Channel][c] = struct{}{}
+			h.mu.Unlock()
+		case "unsub":
+			h.mu.Lock()
+			delete(h.subs[f.Channel], c)
+			if len(h.subs[f.Channel]) == 0 {
+				delete(h.subs, f.Channel)
+			}
+			h.mu.Unlock()
+		case "pub":
+			h.mu.Lock()
+			subs := make([]*hubClient, 0, len(h.subs[f.Channel]))
+			for sub := range h.subs[f.Channel] {
+				subs = append(subs, sub)
+			}
+			h.mu.Unlock()
+			for _, sub := range subs {
+				if err := sub.write(f); err != nil {
+					h.app.config.Logger.Debug("backplane hub failed to relay message", "channel", f.Channel, "error", err)
+				}
+			}
+		}
+	}
+}
+
+// maxBackplanePending limits the messages waiting for delivery per channel
+// of a SocketBackplane. The oldest are dropped beyond it.
+const maxBackplanePending = 1024
+
+// SocketBackplane is a Backplane connected to a BackplaneHub.
+//
+// Messages are delivered to the subscribers of each channel in order, on a
+// goroutine per channel, so a slow subscriber does not hold up other
+// channels or the connection to the hub.
+type SocketBackplane struct {
+	app        *App
+	conn       net.Conn
+	wmu        sync.Mutex
+	enc        *json.Encoder
+	mu         sync.Mutex
+	nextID     int
+	subs       map[string]*backplaneChannel
+	deliveries sync.WaitGroup
+	done       chan struct{}
+}
+
+// backplaneChannel holds the subscribers of a channel and the messages
+// waiting to be delivered to them
+type backplaneChannel struct {
+	fns        map[int]func(msg []byte)
+	pending    [][]byte
+	delivering bool
+}
+
+// DialBackplane connects to a BackplaneHub, logging through the default
+// App. See App.DialBackplane.
+func DialBackplane(network, address string) (*SocketBackplane, error) {
+	return defaultApp.DialBackplane(network, address)
+}
+
+// DialBackplane connects to the BackplaneHub at the network address, logging
+// through the App's Config.Logger
+func (a *App) DialBackplane(network, address string) (*SocketBackplane, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	b := &SocketBackplane{
+		app:  a,
+		conn: conn,
+		enc:  json.NewEncoder(conn),
+		subs: make(map[string]*backplaneChannel),
+		done: make(chan struct{}),
+	}
+	a.goroutines.Go(goBackplane, b.read)
+	return b, nil
+}
+
+func (b *SocketBackplane) write(ctx context.Context, f backplaneFrame) error {
+	b.wmu.Lock()
+	defer b.wmu.Unlock()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Time{}
+	}
+	b.conn.SetWriteDeadline(deadline)
+	return b.enc.Encode(f)
+}
+
+// read queues each message from the hub for delivery on its channel
+func (b *SocketBackplane) read() {
+	defer close(b.done)
+	dec := json.NewDecoder(b.conn)
+	for {
+		var f backplaneFrame
+		if err := dec.Decode(&f); err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				b.app.config.Logger.Error("backplane connection lost", "error", err)
+			}
+			return
+		}
+		b.mu.Lock()
+		ch, ok := b.subs[f.Channel]
+		if !ok {
+			b.mu.Unlock()
+			continue
+		}
+		if len(ch.pending) >= maxBackplanePending {
+			ch.pending = ch.pending[1:]
+			b.app.config.Logger.Warn("backplane subscriber too slow, dropping message", "channel", f.Channel)
+		}
+		ch.pending = append(ch.pending, f.Data)
+		if !ch.delivering {
+			ch.delivering = true
+			b.deliveries.Add(1)
+			b.app.goroutines.Go(goBackplane, func() { b.deliver(ch) })
+		}
+		b.mu.Unlock()
+	}
+}
+
+// deliver calls the subscribers of ch with its pending messages until there
+// are none left
+func (b *SocketBackplane) deliver(ch *backplaneChannel) {
+	defer b.deliveries.Done()
+	for {
+		b.mu.Lock()
+		if len(ch.pending) == 0 {
+			ch.delivering = false
+			b.mu.Unlock()
+			return
+		}
+		msg := ch.pending[0]
+		ch.pending[0] = nil
+		ch.pending = ch.pending[1:]
+		fns := make([]func(msg []byte), 0, len(ch.fns))
+		for _, fn := range ch.fns {
+			fns = append(fns, fn)
+		}
+		b.mu.Unlock()
+		for _, fn := range fns {
+			fn(msg)
+		}
+	}
+}
+
+func (b *SocketBackplane) Publish(ctx context.Context, channel string, msg []byte) error {
+	return b.write(ctx, backplaneFrame{Op: "pub", Channel: channel, Data: msg})
+}
+
+func (b *SocketBackplane) Subscribe(channel string, fn func(msg []byte)) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch, ok := b.subs[channel]
+	if !ok {
+		if err := b.write(context.Background(), backplaneFrame{Op: "sub", Channel: channel}); err != nil {
+			return nil, err
+		}
+		ch = &backplaneChannel{fns: make(map[int]func(msg []byte))}
+		b.subs[channel] = ch
+	}
+	b.nextID++
+	id := b.nextID
+	ch.fns[id] = fn
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(ch.fns, id)
+		if len(ch.fns) == 0 && b.subs[channel] == ch {
+			delete(b.subs, channel)
+			b.write(context.Background(), backplaneFrame{Op: "unsub", Channel: channel})
+		}
+	}, nil
+}
+
+// Close disconnects from the hub and waits for messages being delivered
+func (b *SocketBackplane) Close() error {
+	err := b.conn.Close()
+	<-b.done
+	b.deliveries.Wait()
+	return err
+}
@@ -0,0 +1,194 @@
+package fncmp
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackplane(t *testing.T) {
+	hub, err := ListenBackplane("unix", filepath.Join(t.TempDir(), "fncmp.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.Close()
+
+	dial := func() Backplane {
+		b, err := DialBackplane(hub.Addr().Network(), hub.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	cases := []struct {
+		name      string
+		publisher Backplane
+		receiver  Backplane
+	}{
+		{"memory", NewMemoryBackplane(), nil},
+		{"socket", dial(), dial()},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer c.publisher.Close()
+			if c.receiver == nil {
+				c.receiver = c.publisher
+			} else {
+				defer c.receiver.Close()
+			}
+
+			received := make(chan string, 16)
+			unsub, err := c.receiver.Subscribe("topic:test", func(msg []byte) {
+				received <- string(msg)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Publish until the hub has processed the subscription
+			ctx := context.Background()
+			deadline := time.After(time.Second)
+		wait:
+			for {
+				if err := c.publisher.Publish(ctx, "topic:test", []byte("test")); err != nil {
+					t.Fatal(err)
+				}
+				select {
+				case msg := <-received:
+					if msg != "test" {
+						t.Errorf("expected test, got %s", msg)
+					}
+					break wait
+				case <-time.After(10 * time.Millisecond):
+				case <-deadline:
+					t.Fatal("expected message, got nothing")
+				}
+			}
+
+			unsub()
+			time.Sleep(10 * time.Millisecond)
+			for len(received) > 0 {
+				<-received
+			}
+			c.publisher.Publish(ctx, "topic:test", []byte("test"))
+			select {
+			case msg := <-received:
+				t.Errorf("expected nothing after unsubscribe, got %s", msg)
+			case <-time.After(20 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestSocketBackplaneDelivery(t *testing.T) {
+	a := NewApp(&Config{Silent: true})
+	hub, err := a.ListenBackplane("unix", filepath.Join(t.TempDir(), "fncmp.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.Close()
+	b, err := a.DialBackplane(hub.Addr().Network(), hub.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	release := make(chan struct{})
+	slow, fast := make(chan string, 16), make(chan string, 16)
+	if _, err := b.Subscribe("topic:slow", func(msg []byte) {
+		<-release
+		slow <- string(msg)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Subscribe("topic:fast", func(msg []byte) {
+		fast <- string(msg)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A blocked subscriber does not hold up other channels
+	ctx := context.Background()
+	for _, msg := range []string{"1", "2", "3"} {
+		if err := b.Publish(ctx, "topic:slow", []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.After(time.Second)
+wait:
+	for {
+		if err := b.Publish(ctx, "topic:fast", []byte("fast")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-fast:
+			break wait
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("expected message on the fast channel")
+		}
+	}
+
+	// Messages on a channel are delivered in order
+	close(release)
+	for _, exp := range []string{"1", "2", "3"} {
+		select {
+		case msg := <-slow:
+			if msg != exp {
+				t.Errorf("expected %s, got %s", exp, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s", exp)
+		}
+	}
+}
+
+func TestDispatchTo(t *testing.T) {
+	cases := []struct {
+		name     string
+		html     string
+		noOutbox bool
+	}{
+		{"rendered", "<p>test</p>", false},
+		// Pages rendering no HTML send nothing before the remote dispatch
+		{"empty", "", false},
+		// Connections are pooled before the outbox of their page is reset
+		{"no outbox", "<p>test</p>", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := _test_server(func(ctx context.Context) FnComponent {
+				return NewFn(ctx, HTML(c.html))
+			})
+			defer server.Close()
+
+			ws := _test_dial(t, _test_url(defaultApp, server, "/", t.Name()), nil)
+			if c.html != "" {
+				_test_read(t, ws, render)
+			}
+			for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+				if _, ok := defaultApp.connPool.Get(t.Name()); ok {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("expected connection")
+				}
+			}
+			if c.noOutbox {
+				defaultApp.outboxes.Delete(t.Name())
+			}
+
+			fn := NewFn(context.Background(), HTML("<p>remote</p>")).SwapElementInner("target")
+			if err := DispatchTo(context.Background(), t.Name(), fn); err != nil {
+				t.Fatal(err)
+			}
+			d := _test_read(t, ws, render)
+			if d.FnRender.TargetID != "target" || d.ConnID != t.Name() || d.Seq == 0 {
+				t.Errorf("unexpected dispatch %+v", d)
+			}
+		})
+	}
+}
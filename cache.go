@@ -15,6 +15,7 @@ const (
 )
 
 type Cache[T any] struct {
+	app       *App
 	data      T
 	storeKey  string
 	cacheKey  string
@@ -29,7 +30,8 @@ type Cache[T any] struct {
 // Set timeout to 0 or leave empty for default expiry.
 func (c *Cache[T]) Set(data T, timeout ...time.Duration) error {
 	c.data = data
-	cache, err := getCache[T](c.storeKey, c.cacheKey)
+	a := orDefault(c.app)
+	cache, err := getCache[T](a, c.storeKey, c.cacheKey)
 	if err != nil && !errors.Is(err, ErrCacheNotFound) {
 		return err
 	}
@@ -38,39 +40,33 @@ func (c *Cache[T]) Set(data T, timeout ...time.Duration) error {
 		switch t {
 		case 0:
 			if errors.Is(err, ErrCacheNotFound) {
-				c.timeOut = config.CacheTimeOut
+				c.timeOut = a.config.CacheTimeOut
 			} else {
 				c.timeOut = cache.timeOut
 			}
 		default:
-			if t > 0 && t < config.CacheTimeOut {
+			if t > 0 && t < a.config.CacheTimeOut {
 				c.timeOut = t
 			} else {
-				c.timeOut = config.CacheTimeOut
+				c.timeOut = a.config.CacheTimeOut
 			}
 		}
 	}
 	if len(timeout) == 0 {
-		c.timeOut = config.CacheTimeOut
+		c.timeOut = a.config.CacheTimeOut
 	}
 
-	// If updatedAt is zero, the cache is new
-	// start expiry watcher
-	if cache.updatedAt.IsZero() {
-		c.updatedAt = time.Now()
-		go c.watchExpiry()
-	}
 	c.updatedAt = time.Now()
 	cache.data = data
 
-	go c.watchExpiry()
-	err = setCache(c.storeKey, c.cacheKey, cache)
+	c.watchExpiry(a)
+	err = setCache(a, c.storeKey, c.cacheKey, cache)
 	return err
 }
 
 // Value returns the current value of the cache
 func (c *Cache[T]) Value() T {
-	cache, err := getCache[T](c.storeKey, c.cacheKey)
+	cache, err := getCache[T](orDefault(c.app), c.storeKey, c.cacheKey)
 	if err != nil {
 		return *new(T)
 	}
@@ -79,7 +75,7 @@ func (c *Cache[T]) Value() T {
 
 // Delete removes the cache from the store
 func (c *Cache[T]) Delete() {
-	deleteCache(c.storeKey, c.cacheKey)
+	deleteCache(orDefault(c.app), c.storeKey, c.cacheKey)
 }
 
 // CreatedAt returns the time the cache was created
@@ -109,6 +105,7 @@ func (c *Cache[T]) Record(r bool) {
 // GetHistory returns the history of the cache
 func (c *Cache[T]) History() (map[string]T, bool) {
 	c.record = false
+	onfns := &orDefault(c.app).onfns
 	onfns.mu.Lock()
 	defer onfns.mu.Unlock()
 	h, ok := onfns.history[c.storeKey+c.cacheKey]
@@ -126,20 +123,28 @@ func (c *Cache[T]) History() (map[string]T, bool) {
 	return history, true
 }
 
-// watchExpiry watches the cache for expiry and, when it expires,
-// calls the onTimeOut function and deletes the cache.
-func (c *Cache[T]) watchExpiry() {
+// watchExpiry schedules the cache to expire after its timeout, replacing any
+// earlier schedule. When it expires the onTimeOut function is called and the
+// cache is deleted.
+func (c *Cache[T]) watchExpiry(a *App) {
 	if c.timeOut <= 0 {
 		return
 	}
-	for {
-		time.Sleep(c.timeOut)
-		if time.Now().After(c.Expiry()) {
-			callOnFn(onTimeOut, *c)
-			c.Delete()
-			break
-		}
+	s, ok := a.sm.get(c.storeKey)
+	if !ok {
+		return
 	}
+	t := a.scheduler.After(c.timeOut, func() {
+		callOnFn(a, onTimeOut, *c)
+		c.Delete()
+	})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timers == nil {
+		s.timers = make(map[any]*task)
+	}
+	s.timers[c.cacheKey].Cancel()
+	s.timers[c.cacheKey] = t
 }
 
 func NewCache[T any](ctx context.Context, key string, initial T) (c Cache[T], err error) {
@@ -148,8 +153,9 @@ func NewCache[T any](ctx context.Context, key string, initial T) (c Cache[T], er
 	if !ok {
 		return empty, ErrCtxMissingDispatch
 	}
+	a := dispatch.app()
 	// Check if the cache already exists
-	_, err = getCache[T](dispatch.ConnID, key)
+	_, err = getCache[T](a, dispatch.storeKey(), key)
 	if err == nil {
 		return empty, ErrCacheExists
 	}
@@ -158,13 +164,13 @@ func NewCache[T any](ctx context.Context, key string, initial T) (c Cache[T], er
 	}
 
 	// Create a new cache
-	cache, ok := newCache(dispatch.ConnID, key, initial)
+	cache, ok := newCache(a, dispatch.storeKey(), key, initial)
 	if !ok {
 		return empty, ErrStoreNotFound
 	}
 	// Set the initial value of the cache
 	cache.data = initial
-	err = setCache(dispatch.ConnID, key, cache)
+	err = setCache(a, dispatch.storeKey(), key, cache)
 	if err != nil {
 		return empty, err
 	}
@@ -180,7 +186,7 @@ func UseCache[T any](ctx context.Context, key string) (c Cache[T], err error) {
 	if !ok {
 		return empty, ErrCtxMissingDispatch
 	}
-	cache, err := getCache[T](dispatch.ConnID, key)
+	cache, err := getCache[T](dispatch.app(), dispatch.storeKey(), key)
 	if err != nil {
 		return empty, err
 	}
@@ -196,30 +202,25 @@ type _onfns struct {
 	history   map[string]map[string]any
 }
 
-var onfns = _onfns{
-	onchange:  make(map[string]any),
-	ontimeout: make(map[string]any),
-	history:   make(map[string]map[string]any),
-}
-
 func (o *_onfns) Delete(id string) {
-	onfns.mu.Lock()
-	defer onfns.mu.Unlock()
-	delete(onfns.onchange, id)
-	delete(onfns.ontimeout, id)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.onchange, id)
+	delete(o.ontimeout, id)
 }
 
 func (o *_onfns) AddHistory(id string, data any) {
-	onfns.mu.Lock()
-	defer onfns.mu.Unlock()
-	if _, ok := onfns.history[id]; !ok {
-		onfns.history[id] = make(map[string]any)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, ok := o.history[id]; !ok {
+		o.history[id] = make(map[string]any)
 	}
-	onfns.history[id][time.Now().String()] = data
+	o.history[id][time.Now().String()] = data
 }
 
 // OnCacheTimeOut sets a function to be called when the cache expires
 func OnCacheTimeOut[T any](c Cache[T], f func()) {
+	onfns := &orDefault(c.app).onfns
 	onfns.mu.Lock()
 	defer onfns.mu.Unlock()
 	onfns.ontimeout[c.storeKey+c.cacheKey] = f
@@ -227,12 +228,14 @@ func OnCacheTimeOut[T any](c Cache[T], f func()) {
 
 // OnChange sets a function to be called when the cache is updated
 func OnCacheChange[T any](c Cache[T], f func()) {
+	onfns := &orDefault(c.app).onfns
 	onfns.mu.Lock()
 	defer onfns.mu.Unlock()
 	onfns.onchange[c.storeKey+c.cacheKey] = f
 }
 
-func callOnFn[T any](on CacheOnFn, c Cache[T]) {
+func callOnFn[T any](a *App, on CacheOnFn, c Cache[T]) {
+	onfns := &a.onfns
 	onfns.mu.Lock()
 	defer onfns.mu.Unlock()
 	switch on {
@@ -260,10 +263,6 @@ func callOnFn[T any](on CacheOnFn, c Cache[T]) {
 
 // NOTE: The following is some rewritten logic from package mnemo and will be extracted.
 
-var sm = storeManager{
-	stores: make(map[interface{}]*store),
-}
-
 type storeManager struct {
 	mu     sync.Mutex
 	stores map[interface{}]*store
@@ -272,6 +271,8 @@ type storeManager struct {
 type store struct {
 	mu    sync.Mutex
 	cache map[any]any
+	// timers expire caches, see Cache.watchExpiry
+	timers map[any]*task
 }
 
 func (sm *storeManager) get(key interface{}) (*store, bool) {
@@ -295,28 +296,35 @@ func (sm *storeManager) set(key interface{}) {
 func (sm *storeManager) delete(key interface{}) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
+	if s, ok := sm.stores[key]; ok {
+		s.mu.Lock()
+		for _, t := range s.timers {
+			t.Cancel()
+		}
+		s.mu.Unlock()
+	}
 	delete(sm.stores, key)
 }
 
-func setCache[T any](storeKey string, cacheKey string, c Cache[T]) error {
-	s, ok := sm.get(storeKey)
+func setCache[T any](a *App, storeKey string, cacheKey string, c Cache[T]) error {
+	s, ok := a.sm.get(storeKey)
 	if !ok {
 		return ErrStoreNotFound
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.cache[cacheKey] = c
-	callOnFn(onChange, c)
+	callOnFn(a, onChange, c)
 	return nil
 }
 
-func newCache[T any](storeKey string, cacheKey string, cache T) (Cache[T], bool) {
-	s, ok := sm.get(storeKey)
+func newCache[T any](a *App, storeKey string, cacheKey string, cache T) (Cache[T], bool) {
+	s, ok := a.sm.get(storeKey)
 	if !ok {
-		sm.set(storeKey)
-		s, ok = sm.get(storeKey)
+		a.sm.set(storeKey)
+		s, ok = a.sm.get(storeKey)
 		if !ok {
-			config.Logger.Debug("failed to create new cache store", "storeKey", storeKey, "cacheKey", cacheKey)
+			a.config.Logger.Debug("failed to create new cache store", "storeKey", storeKey, "cacheKey", cacheKey)
 			return Cache[T]{}, false
 		}
 		s.cache = make(map[any]any)
@@ -333,6 +341,7 @@ func newCache[T any](storeKey string, cacheKey string, cache T) (Cache[T], bool)
 	s.cache[cacheKey] = c
 
 	copy := Cache[T]{
+		app:       a,
 		storeKey:  storeKey,
 		cacheKey:  cacheKey,
 		createdAt: c.createdAt,
@@ -342,9 +351,9 @@ func newCache[T any](storeKey string, cacheKey string, cache T) (Cache[T], bool)
 	return copy, true
 }
 
-func getCache[T any](storeKey string, cacheKey string) (Cache[T], error) {
+func getCache[T any](a *App, storeKey string, cacheKey string) (Cache[T], error) {
 	cache := Cache[T]{}
-	s, ok := sm.get(storeKey)
+	s, ok := a.sm.get(storeKey)
 	if !ok {
 		return cache, ErrCacheNotFound
 	}
@@ -363,13 +372,15 @@ func getCache[T any](storeKey string, cacheKey string) (Cache[T], error) {
 	return d, nil
 }
 
-func deleteCache(storeKey string, cacheKey string) {
-	s, ok := sm.get(storeKey)
+func deleteCache(a *App, storeKey string, cacheKey string) {
+	s, ok := a.sm.get(storeKey)
 	if !ok {
-		config.Logger.Debug("could not delete cache, no such store", "storeKey", storeKey, "cacheKey", cacheKey)
+		a.config.Logger.Debug("could not delete cache, no such store", "storeKey", storeKey, "cacheKey", cacheKey)
 		return
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	delete(s.cache, cacheKey)
+	s.timers[cacheKey].Cancel()
+	delete(s.timers, cacheKey)
 }
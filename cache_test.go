@@ -20,11 +20,11 @@ func init() {
 		Prefix:          "TESTING fncmp:",
 	}
 	logOpts = opts
-	config = &Config{
+	SetConfig(&Config{
 		CacheTimeOut: 5 * time.Minute,
 		LogLevel:     Debug,
 		Logger:       log.NewWithOptions(os.Stderr, logOpts),
-	}
+	})
 }
 
 func TestMain(m *testing.M) {
@@ -0,0 +1,102 @@
+package fncmp
+
+import (
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+)
+
+// Codec encodes dispatches sent over a connection.
+//
+// The codec of a connection is negotiated through the WebSocket subprotocol:
+// the client offers codec names and the server picks the first of
+// Config.Codecs it supports. Clients that offer none use JSONCodec.
+type Codec interface {
+	// Name is the WebSocket subprotocol for the codec, e.g. "fncmp.json"
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+	// MessageType is websocket.TextMessage or websocket.BinaryMessage
+	MessageType() int
+}
+
+// transcoder is implemented by codecs that can convert a frame to JSON, so
+// it can be checked by decodeDispatch before decoding
+type transcoder interface {
+	toJSON(data []byte) ([]byte, error)
+}
+
+// JSONCodec encodes dispatches as JSON text frames. It is the default.
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string                       { return "fncmp.json" }
+func (JSONCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (JSONCodec) MessageType() int                   { return websocket.TextMessage }
+func (JSONCodec) toJSON(data []byte) ([]byte, error) { return data, nil }
+
+// MessagePackCodec encodes dispatches as MessagePack binary frames.
+//
+// Dispatches are encoded with the json names of their fields, leaving out
+// zero values, which the client fills in. Other values, including event and
+// custom data, are encoded as their JSON representation would be, so the
+// json struct tags and Marshaler implementations of the Go types still
+// apply.
+type MessagePackCodec struct{}
+
+func (MessagePackCodec) Name() string     { return "fncmp.msgpack" }
+func (MessagePackCodec) MessageType() int { return websocket.BinaryMessage }
+
+func (MessagePackCodec) Marshal(v any) ([]byte, error) {
+	switch d := v.(type) {
+	case Dispatch:
+		return appendMsgpackDispatch(nil, &d)
+	case *Dispatch:
+		return appendMsgpackDispatch(nil, d)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var tree any
+	if err := unmarshalNumbers(b, &tree); err != nil {
+		return nil, err
+	}
+	return appendMsgpack(nil, tree)
+}
+
+func (m MessagePackCodec) Unmarshal(data []byte, v any) error {
+	b, err := m.toJSON(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (MessagePackCodec) toJSON(data []byte) ([]byte, error) {
+	tree, err := decodeMsgpack(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(tree)
+}
+
+// negotiateCodec returns the codec for the subprotocol chosen during the
+// upgrade
+func (a *App) negotiateCodec(subprotocol string) Codec {
+	for _, codec := range a.config.Codecs {
+		if codec.Name() == subprotocol {
+			return codec
+		}
+	}
+	return JSONCodec{}
+}
+
+// subprotocols returns the names of Config.Codecs in order of preference
+func (a *App) subprotocols() []string {
+	names := make([]string, len(a.config.Codecs))
+	for i, codec := range a.config.Codecs {
+		names[i] = codec.Name()
+	}
+	return names
+}
@@ -0,0 +1,151 @@
+package fncmp
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMessagePackCodec(t *testing.T) {
+	codec := MessagePackCodec{}
+	d := Dispatch{
+		Function: event,
+		Seq:      300,
+		ConnID:   strings.Repeat("x", 40),
+		FnEvent: EventListener{
+			ID: "el",
+			Data: map[string]any{
+				"n":    -70000.0,
+				"f":    1.5,
+				"list": []any{true, nil, "s"},
+			},
+		},
+	}
+	b, err := codec.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Dispatch
+	if err := codec.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Seq != d.Seq || got.ConnID != d.ConnID || got.FnEvent.ID != "el" {
+		t.Errorf("expected %+v, got %+v", d, got)
+	}
+	if !reflect.DeepEqual(got.FnEvent.Data, d.FnEvent.Data) {
+		t.Errorf("expected %v, got %v", d.FnEvent.Data, got.FnEvent.Data)
+	}
+
+	for _, bad := range [][]byte{{0xdb, 0xff, 0xff, 0xff, 0xff}, {0xdd, 0xff, 0xff, 0xff, 0xff}, {0x81, 0x01, 0x01}, {0xc1}} {
+		if err := codec.Unmarshal(bad, &got); err == nil {
+			t.Errorf("expected error decoding %x", bad)
+		}
+	}
+}
+
+func TestMessagePackDispatch(t *testing.T) {
+	codec := MessagePackCodec{}
+	id := "6b1f3c52-54d8-4a3e-9f7e-0c2d9a1b7e44"
+
+	// Only the sub-struct of the function is sent
+	ping := Dispatch{ID: id, Key: id, ConnID: id, HandlerID: id, Function: ping, FnPing: FnPing{Server: true, Sent: 1760000000000}}
+	b, err := codec.Marshal(ping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j, _ := (JSONCodec{}).Marshal(ping); len(b) > 240 || len(b) > len(j)/2 {
+		t.Errorf("expected a ping of at most 240 bytes, got %d (%d as JSON)", len(b), len(j))
+	}
+	tree, err := decodeMsgpack(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]any{
+		"id": id, "key": id, "conn_id": id, "handler_id": id, "function": "ping",
+		"ping": map[string]any{"server": true, "sent": int64(1760000000000)},
+	}
+	if !reflect.DeepEqual(tree, exp) {
+		t.Errorf("expected %v, got %v", exp, tree)
+	}
+
+	// Frames decode as their JSON encoding does
+	full := Dispatch{
+		ID: id, Seq: 1 << 40, Key: "k", ConnID: id, HandlerID: id, Action: "a", Label: "l", Function: render,
+		FnAuth:  FnAuth{Key: "k", Token: "t"},
+		FnEvent: EventListener{ID: "e", TargetID: "t", On: OnClick, Data: map[string]any{"n": 1.5, "zero": 0.0}},
+		FnPing:  FnPing{Client: true, Sent: -1},
+		FnRender: FnRender{TargetID: "t", Tag: "div", Inner: true, Outer: true, Append: true, Prepend: true, Remove: true, HTML: "<p>x</p>",
+			EventListeners: []EventListener{{ID: "a", On: OnInput}, {ID: "b", Data: []any{"x", false}}}},
+		FnClass:    FnClass{TargetID: "t", Remove: true, Names: []string{"a", "b"}},
+		FnRedirect: FnRedirect{URL: "/x", Navigate: true, Pop: true},
+		FnCustom:   FnCustom{Function: "f", Data: "d", Result: map[string]any{"ok": true}},
+		FnError:    FnError{Message: "m", Code: "c", Reload: true},
+	}
+	for _, d := range []Dispatch{ping, full, {}} {
+		var exp, got Dispatch
+		j, _ := JSONCodec{}.Marshal(d)
+		if err := json.Unmarshal(j, &exp); err != nil {
+			t.Fatal(err)
+		}
+		b, err := codec.Marshal(&d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := codec.Unmarshal(b, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("expected %+v, got %+v", exp, got)
+		}
+	}
+}
+
+func TestCodecNegotiation(t *testing.T) {
+	codecs := defaultApp.config.Codecs
+	defaultApp.config.Codecs = []Codec{MessagePackCodec{}, JSONCodec{}}
+	defer func() { defaultApp.config.Codecs = codecs }()
+
+	server := _test_server(func(ctx context.Context) FnComponent {
+		return NewFn(ctx, HTML("<p>test</p>"))
+	})
+	defer server.Close()
+
+	cases := []struct {
+		name         string
+		subprotocols []string
+		typ          int
+	}{
+		{"msgpack", []string{"fncmp.msgpack", "fncmp.json"}, websocket.BinaryMessage},
+		{"json", []string{"fncmp.json"}, websocket.TextMessage},
+		{"none", nil, websocket.TextMessage},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dialer := websocket.Dialer{Subprotocols: c.subprotocols}
+			ws, _, err := dialer.Dial(_test_url(defaultApp, server, "/", t.Name()), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ws.Close()
+			// The first dispatch may be a ping or the initial render
+			typ, msg, err := ws.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if typ != c.typ {
+				t.Fatalf("expected message type %d, got %d", c.typ, typ)
+			}
+			var d Dispatch
+			if err := defaultApp.negotiateCodec(ws.Subprotocol()).Unmarshal(msg, &d); err != nil {
+				t.Fatal(err)
+			}
+			if d.Function != render && d.Function != ping {
+				t.Errorf("expected render or ping, got %s", d.Function)
+			}
+		})
+	}
+}
@@ -39,7 +39,7 @@ func NewFn(ctx context.Context, c Component) FnComponent {
 	dispatch := newDispatch(id)
 	dd, ok := ctx.Value(dispatchKey).(dispatchDetails)
 	if !ok {
-		config.Logger.Warn(ErrCtxMissingDispatch)
+		defaultApp.config.Logger.Warn(ErrCtxMissingDispatch)
 	} else {
 		dispatch.conn = dd.Conn
 		dispatch.ConnID = dd.ConnID
@@ -82,7 +82,7 @@ func (f FnComponent) WithContext(ctx context.Context) FnComponent {
 
 	dd, ok := ctx.Value(dispatchKey).(dispatchDetails)
 	if !ok {
-		config.Logger.Error(ErrCtxMissingDispatch)
+		defaultApp.config.Logger.Error(ErrCtxMissingDispatch)
 		return f
 	}
 	f.dispatch.ConnID = dd.ConnID
@@ -132,6 +132,26 @@ func (f FnComponent) JS(fn string, arg any) FnComponent {
 // especially during debugging.
 func (f FnComponent) WithLabel(label string) FnComponent {
 	f.dispatch.Label = label
+	f.updateListeners(func(el *EventListener) { el.label = label })
+	return f
+}
+
+// updateListeners applies fn to the event listeners already added to f
+func (f FnComponent) updateListeners(fn func(el *EventListener)) {
+	c := f.dispatch.conn
+	for i := range f.dispatch.FnRender.EventListeners {
+		el := &f.dispatch.FnRender.EventListeners[i]
+		fn(el)
+		if c != nil {
+			c.app.evtListeners.Add(c, *el)
+		}
+	}
+}
+
+// OnAck sets a function to be called once the client acknowledges that it
+// applied the FnComponent, e.g. when a render has landed in the DOM.
+func (f FnComponent) OnAck(fn func()) FnComponent {
+	f.dispatch.onAck = fn
 	return f
 }
 
@@ -230,15 +250,16 @@ func (f FnComponent) SwapElementInner(id string) FnComponent {
 // Dispatch immediately sends the FnComponent to the client
 func (f FnComponent) Dispatch() {
 	if f.dispatch.conn == nil {
-		config.Logger.Error(ErrConnectionNotFound)
+		defaultApp.config.Logger.Error(ErrConnectionNotFound)
 		return
 	}
-	h, ok := handlers.Get(f.dispatch.HandlerID)
+	a := f.dispatch.conn.app
+	h, ok := a.handlers.Get(f.dispatch.HandlerID)
 	if !ok {
-		config.Logger.Error("handler not found", "HandlerID", f.dispatch.HandlerID)
+		a.config.Logger.Error("handler not found", "HandlerID", f.dispatch.HandlerID)
 		return
 	}
-	h.out <- f
+	h.queueOut(f)
 }
 
 // FnErr returns a FnComponent with an error message
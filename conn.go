@@ -1,20 +1,21 @@
 package fncmp
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-var connPool = conns{
-	pool: make(map[string]*conn),
-}
-
 func (c *conns) Get(id string) (*conn, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -28,72 +29,314 @@ func (c *conns) Set(id string, conn *conn) {
 	c.pool[id] = conn
 }
 
+// All returns every active connection
+func (c *conns) All() []*conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	all := make([]*conn, 0, len(c.pool))
+	for _, conn := range c.pool {
+		all = append(all, conn)
+	}
+	return all
+}
+
 func (c *conns) Delete(id string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	delete(c.pool, id)
 }
 
+// Remove deletes conn from the pool only if it is still the active
+// connection for its ID, so a reconnected client is not dropped.
+func (c *conns) Remove(conn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pool[conn.ID] == conn {
+		delete(c.pool, conn.ID)
+	}
+}
+
 type (
 	conns struct {
 		mu   sync.Mutex
 		pool map[string]*conn
 	}
 	conn struct {
-		websocket *websocket.Conn
-		ID        string
-		HandlerID string
-		LastPing  time.Time
-		Key       string
-		Messages  chan []byte
+		mu         sync.Mutex
+		app        *App
+		websocket  *websocket.Conn
+		ID         string
+		Session    string        // Shared by every tab of the browser
+		HandlerID  string        // Primary handler
+		handlers   []string      // Every handler carried by the connection
+		request    *http.Request // Request for the page, changed by Router navigation
+		opened     *http.Request // Request that opened the connection
+		connected  time.Time
+		principal  any           // Returned by Config.Authenticator
+		restricted bool          // Failed to authenticate, served by Config.UnauthenticatedHandler
+		codec      Codec         // Negotiated through the WebSocket subprotocol
+		version    int           // Protocol version of the client
+		LastPing   time.Time     // When the client last answered a ping
+		RTT        time.Duration // Round-trip time of the last ping
+		Key        string
+		// awaiting is when the unanswered ping was sent, if any
+		awaiting       time.Time
+		missed         int
+		malformedCount int
+		limiter        *tokenBucket
+		listenerLimits map[string]*listenerLimit
+		queue          *messageQueue
+		dropped        atomic.Uint64
+		done           chan struct{}
+		closeOnce      sync.Once
+		// ctx is cancelled with ErrConnectionClosed when the connection closes
+		ctx    context.Context
+		cancel context.CancelCauseFunc
+		// eventSeqs is the last sequence number given to an event of each
+		// listener, in order of arrival
+		eventSeqs map[string]uint64
+		// events is the latest event started for each listener
+		events map[string]*eventRun
 	}
 )
 
-func newConn(w http.ResponseWriter, r *http.Request, handlerID string, ID string) (*conn, error) {
+func (a *App) newConn(w http.ResponseWriter, r *http.Request, handlerIDs []string, session string, ID string, version int) (*conn, error) {
+	// Check origin before upgrading so the caller controls the response
+	if !a.checkOrigin(r) {
+		return nil, fmt.Errorf("%w: %s", ErrOriginNotAllowed, r.Header.Get("Origin"))
+	}
+	// A token sent with the handshake is checked before upgrading, otherwise
+	// the client must send it in an auth dispatch
+	var principal any
+	var authErr error
+	pending := false
+	if a.config.Authenticator != nil {
+		token, ok := handshakeToken(r)
+		if ok || version == legacyProtocolVersion {
+			// Clients of the previous version send no auth dispatch
+			principal, authErr = a.authenticate(r.Context(), token)
+		}
+		pending = !ok && version != legacyProtocolVersion
+		if authErr != nil && a.config.UnauthenticatedHandler == nil {
+			http.Error(w, ErrUnauthorized.Error(), http.StatusUnauthorized)
+			return nil, authErr
+		}
+	}
 	upgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
+		CheckOrigin:  a.checkOrigin,
+		Subprotocols: a.subprotocols(),
 	}
-	websocket, err := upgrader.Upgrade(w, r, nil)
+	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return nil, errors.New("failed to upgrade connection")
 	}
 
 	c := &conn{
-		websocket: websocket,
+		app:       a,
+		websocket: ws,
 		ID:        ID,
-		HandlerID: handlerID,
-		Messages:  make(chan []byte, 16),
+		Session:   session,
+		HandlerID: handlerIDs[0],
+		handlers:  handlerIDs,
+		request:   pageRequest(r),
+		connected: time.Now(),
+		codec:     a.negotiateCodec(ws.Subprotocol()),
+		queue:     newMessageQueue(a.config.MessageBufferSize),
+		done:      make(chan struct{}),
+		limiter:   newTokenBucket(a.config.ConnRateLimit),
+	}
+	c.opened = c.request
+	c.ctx, c.cancel = context.WithCancelCause(context.Background())
+	c.version = version
+	if pending {
+		token, err := c.awaitAuth()
+		if err == nil {
+			principal, err = a.authenticate(r.Context(), token)
+		}
+		if err != nil && a.config.UnauthenticatedHandler == nil {
+			c.reject(ErrUnauthorized.Error())
+			return nil, err
+		}
+		authErr = err
+	}
+	c.principal = principal
+	c.restricted = authErr != nil
+	if c.restricted {
+		a.config.Logger.Warn("connection not authenticated, using restricted handler", "conn_id", ID, "reason", authErr)
+	}
+	// State still held for the ID means the client has been connected before
+	_, reconnect := a.outboxes.Get(c.ID)
+	a.connPool.Set(c.ID, c)
+	// Shutdown may have started while the client was authenticating, after
+	// it stopped accepting connections but too late to find this one
+	if a.shuttingDown.Load() {
+		c.closeWith(websocket.CloseGoingAway, ErrShuttingDown.Error())
+		return nil, ErrShuttingDown
+	}
+	a.subscribeConn(c.ID)
+
+	a.lifecycle.call(&a.lifecycle.onConnect, c)
+	if reconnect {
+		a.lifecycle.call(&a.lifecycle.onReconnect, c)
 	}
-	connPool.Set(c.ID, c)
 	return c, nil
 }
 
+// carries reports whether the connection carries the handler with id
+func (c *conn) carries(id string) bool {
+	for _, h := range c.handlerIDs() {
+		if h == id {
+			return true
+		}
+	}
+	return false
+}
+
+// handlerIDs returns the IDs of every handler carried by the connection
+func (c *conn) handlerIDs() []string {
+	if len(c.handlers) == 0 {
+		return []string{c.HandlerID}
+	}
+	return c.handlers
+}
+
+// withContext returns ctx carrying the connection, its primary handler and
+// the request for the page
+func (c *conn) withContext(ctx context.Context) context.Context {
+	return c.handlerContext(ctx, c.HandlerID)
+}
+
+// handlerContext returns ctx carrying the connection, the handler with
+// handlerID and the request for the page
+func (c *conn) handlerContext(ctx context.Context, handlerID string) context.Context {
+	ctx = context.WithValue(ctx, dispatchKey, dispatchDetails{
+		ConnID:    c.ID,
+		SessionID: c.Session,
+		Conn:      c,
+		HandlerID: handlerID,
+	})
+	ctx = context.WithValue(ctx, ConnIDKey, c.ID)
+	ctx = context.WithValue(ctx, SessionIDKey, c.Session)
+	ctx = context.WithValue(ctx, HandlerIDKey, handlerID)
+	if c.principal != nil {
+		ctx = context.WithValue(ctx, PrincipalKey, c.principal)
+	}
+	return context.WithValue(ctx, RequestKey, c.page())
+}
+
+// page returns the request for the page the client is on
+func (c *conn) page() *http.Request {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.request
+}
+
+// navigate sets the request for the page the client moved to
+func (c *conn) navigate(page *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.request = page
+}
+
+// context returns a context for the connection that outlives the request
+// that opened it
+func (c *conn) context() context.Context {
+	ctx := context.Background()
+	if r := c.page(); r != nil {
+		ctx = context.WithoutCancel(r.Context())
+	}
+	return c.withContext(ctx)
+}
+
+// lifecycleContext returns the context for LifecycleFn, carrying the request
+// that opened the connection rather than the page the client is on
+func (c *conn) lifecycleContext() context.Context {
+	ctx := context.Background()
+	if c.opened != nil {
+		ctx = context.WithoutCancel(c.opened.Context())
+	}
+	return context.WithValue(c.withContext(ctx), RequestKey, c.opened)
+}
+
+// checkOrigin reports whether the request origin may open a connection.
+//
+// Config.CheckOrigin takes precedence when set. Otherwise requests without
+// an Origin header, same-origin requests and origins listed in
+// Config.AllowedOrigins are allowed.
+func (a *App) checkOrigin(r *http.Request) bool {
+	if a.config.CheckOrigin != nil {
+		return a.config.CheckOrigin(r)
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range a.config.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *conn) close() error {
 	if c == nil {
 		return errors.New("cannot close nil connection")
 	}
-	go func() {
-		// After CacheTimeOut, delete cache if connection is not re-established
-		time.Sleep(config.CacheTimeOut)
-		_, ok := connPool.Get(c.ID)
-		if !ok {
-			sm.delete(c.ID)
-		}
-	}()
+	a := c.app
+	c.closeOnce.Do(func() {
+		// After CacheTimeOut, delete session state if connection is not re-established
+		a.scheduler.After(a.config.CacheTimeOut, func() {
+			_, ok := a.connPool.Get(c.ID)
+			if !ok {
+				// The cache store is shared by the session's tabs
+				if !a.sessionActive(c.Session) {
+					a.sm.delete(c.Session)
+				}
+				a.evtListeners.Delete(c)
+				a.outboxes.Delete(c.ID)
+				a.deleteConn(c.ID)
+				a.remote.Unsubscribe(connChannel(c.ID))
+			}
+		})
 
-	evtListeners.Delete(c)
-	connPool.Delete(c.ID)
-	c.websocket.Close()
+		a.connPool.Remove(c)
+		close(c.done)
+		if c.cancel != nil {
+			c.cancel(ErrConnectionClosed)
+		}
+		c.queue.close()
+		c.websocket.Close()
+		a.lifecycle.call(&a.lifecycle.onDisconnect, c)
+	})
 	return nil
 }
 
+// closeWith sends a close frame with code and reason before closing the
+// connection
+func (c *conn) closeWith(code int, reason string) error {
+	msg := websocket.FormatCloseMessage(code, reason)
+	err := c.websocket.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	if err != nil {
+		c.app.config.Logger.Debug("failed to send close frame", "conn_id", c.ID, "error", err)
+	}
+	return c.close()
+}
+
 func (c *conn) listen() {
-	go func(c *conn) {
+	defer c.app.goroutines.track(goConn)()
+	c.app.goroutines.Go(goConn, func() {
 		defer c.close()
-		var dispatch Dispatch
+		c.websocket.SetReadLimit(c.app.config.MaxMessageSize)
 		for {
+			c.websocket.SetReadDeadline(time.Now().Add(c.app.config.ReadTimeout))
 			_, message, err := c.websocket.ReadMessage()
 			if err != nil {
 				if websocket.IsUnexpectedCloseError(
@@ -104,30 +347,51 @@ func (c *conn) listen() {
 				) {
 					log.Printf("error: %v", err)
 				}
-				close(c.Messages)
 				break
 			}
-			// Parse dispatch from websocket message
-			err = json.Unmarshal(message, &dispatch)
-			if err != nil {
-				log.Printf("error: %v", err)
+			// Parse a fresh dispatch from each websocket message
+			var dispatch Dispatch
+			if err := decodeDispatch(c.codec, c.app.config.MaxEventDataDepth, message, &dispatch); err != nil {
+				if c.malformed(err) {
+					break
+				}
+				continue
+			}
+			// Clients authenticate when they connect, later attempts are ignored
+			if dispatch.Function == auth {
 				continue
 			}
 			// Get handler from handler pool
-			handler, ok := handlers.Get(dispatch.HandlerID)
-			if !ok {
-				log.Printf("error: handler '%s' not found", dispatch.HandlerID)
+			handler, ok := c.app.handlers.Get(dispatch.HandlerID)
+			if !ok || !c.carries(dispatch.HandlerID) {
+				err := fmt.Errorf("%w: handler '%s' not found", ErrMalformedFrame, dispatch.HandlerID)
+				if c.malformed(err) {
+					break
+				}
+				continue
+			}
+			// Only pings and acks are handled during Shutdown, so in-flight
+			// handlers can drain
+			if c.app.shuttingDown.Load() && dispatch.Function != ping && dispatch.Function != ack {
 				continue
 			}
 			// Set conn on dispatch
 			dispatch.conn = c
+			// Events are handled concurrently, so their order is kept to
+			// tell which is newer
+			if dispatch.Function == event {
+				dispatch.eventSeq = c.nextEventSeq(dispatch.FnEvent.ID)
+			}
+			if !c.allow(dispatch, handler) {
+				continue
+			}
 			// Dispatch to handler
-			handler.in <- dispatch
+			handler.queueIn(dispatch)
 		}
-	}(c)
+	})
 
 	for {
-		msg, ok := <-c.Messages
+		msg, ok := c.queue.pop()
 		if !ok {
 			c.close()
 			break
@@ -136,32 +400,193 @@ func (c *conn) listen() {
 			break
 		}
 
-		if err := c.websocket.WriteMessage(1, msg); err != nil {
-			config.Logger.Error("error writing message", "error", err)
+		c.websocket.SetWriteDeadline(time.Now().Add(c.app.config.WriteTimeout))
+		if err := c.websocket.WriteMessage(c.codec.MessageType(), msg.data); err != nil {
+			c.app.config.Logger.Error("error writing message", "error", err)
 			c.close()
 		}
 	}
 }
 
-func (c *conn) Publish(msg []byte) {
-	// if msg is not json encodable, return
-	_, err := json.Marshal(msg)
+// malformed records a malformed frame from the client and reports whether
+// the connection was closed for exceeding Config.MaxMalformedFrames
+func (c *conn) malformed(err error) bool {
+	config := c.app.config
+	c.malformedCount++
+	config.Logger.Warn("malformed frame", "conn_id", c.ID, "count", c.malformedCount, "error", err)
+	if c.malformedCount < config.MaxMalformedFrames {
+		return false
+	}
+	config.Logger.Warn("closing connection after malformed frames", "conn_id", c.ID)
+	c.closeWith(websocket.CloseInvalidFramePayloadData, ErrMalformedFrame.Error())
+	return true
+}
+
+// decodeDispatch unmarshals a frame from the client into d, rejecting event
+// data nested deeper than maxDepth.
+//
+// The depth is only checked for codecs that can convert frames to JSON.
+func decodeDispatch(codec Codec, maxDepth int, msg []byte, d *Dispatch) error {
+	t, ok := codec.(transcoder)
+	if !ok {
+		if err := codec.Unmarshal(msg, d); err != nil {
+			return fmt.Errorf("%w: %v", ErrMalformedFrame, err)
+		}
+		return nil
+	}
+	msg, err := t.toJSON(msg)
 	if err != nil {
-		config.Logger.Error("message not json encodable", "error", err)
-		return
+		return fmt.Errorf("%w: %v", ErrMalformedFrame, err)
+	}
+	// Event data is nested within the dispatch and its event listener
+	if jsonDepth(msg) > maxDepth+2 {
+		return fmt.Errorf("%w: exceeds max depth of %d", ErrMalformedFrame, maxDepth)
 	}
+	if err := json.Unmarshal(msg, d); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedFrame, err)
+	}
+	return nil
+}
+
+// jsonDepth returns the maximum nesting depth of objects and arrays in b
+func jsonDepth(b []byte) int {
+	depth, max := 0, 0
+	inString, escaped := false, false
+	for _, c := range b {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{' || c == '[':
+			depth++
+			if depth > max {
+				max = depth
+			}
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return max
+}
+
+// Publish queues msg, which must be encoded with the connection's codec
+func (c *conn) Publish(msg []byte) {
+	c.publish(message{data: msg})
+}
+
+// publish queues m for the client, applying Config.Backpressure if the
+// connection's queue is full
+func (c *conn) publish(m message) {
 	if c == nil {
-		config.Logger.Warn("connection severed, message not sent")
+		defaultApp.config.Logger.Warn("connection severed, message not sent")
 		return
 	}
-	conn, _ := connPool.Get(c.ID)
+	config := c.app.config
+	conn, _ := c.app.connPool.Get(c.ID)
 	if conn != c {
 		return
 	}
-	c.Messages <- msg
+	dropped, err := c.queue.push(m, config.Backpressure, config.PublishTimeout)
+	if dropped > 0 {
+		c.dropped.Add(uint64(dropped))
+		c.app.droppedMessages.Add(uint64(dropped))
+		config.Logger.Warn("dropped messages", "conn_id", c.ID, "dropped", dropped, "policy", config.Backpressure)
+	}
+	if errors.Is(err, ErrBackpressure) {
+		config.Logger.Error(ErrBackpressure, "conn_id", c.ID)
+		c.close()
+	}
+}
+
+// send encodes d and publishes it to the connection
+func (c *conn) send(d Dispatch) error {
+	b, err := c.codec.Marshal(d)
+	if err != nil {
+		return err
+	}
+	c.publish(message{data: b, key: coalesceKey(d)})
+	return nil
+}
+
+// coalesceKey returns the key of a render that replaces its target, so that
+// a newer render to the same target may supersede it
+func coalesceKey(d Dispatch) string {
+	r := d.FnRender
+	if d.Function != render || r.Append || r.Prepend || r.Remove || !(r.Inner || r.Outer) {
+		return ""
+	}
+	return fmt.Sprintf("%s:%s:%t", r.Tag, r.TargetID, r.Inner)
+}
+
+// heartbeat pings the client every Config.PingInterval until the connection
+// is replaced or closed. A ping not answered within Config.PongTimeout is
+// missed, and the connection is closed after Config.MaxMissedPings misses.
+func (c *conn) heartbeat(h handler, d Dispatch) {
+	config := c.app.config
+	ticker := time.NewTicker(config.PingInterval)
+	defer ticker.Stop()
+	for {
+		// Check if connection is still open
+		current, _ := c.app.connPool.Get(c.ID)
+		if current != c {
+			// Connection has been replaced or closed
+			return
+		}
+
+		c.mu.Lock()
+		now := time.Now()
+		if !c.awaiting.IsZero() && now.Sub(c.awaiting) > config.PongTimeout {
+			c.missed++
+			c.awaiting = time.Time{}
+		}
+		missed, send := c.missed, c.awaiting.IsZero()
+		if send {
+			c.awaiting = now
+		}
+		c.mu.Unlock()
+
+		if missed >= config.MaxMissedPings {
+			config.Logger.Warn("closing unresponsive connection", "conn_id", c.ID, "missed_pings", missed)
+			c.close()
+			return
+		}
+		if send {
+			d.FnPing.Sent = now.UnixMilli()
+			h.Ping(d)
+		}
+		select {
+		case <-ticker.C:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// pong records the client's reply to the outstanding ping. sent is echoed
+// by the client and only identifies the ping, so replies to missed pings and
+// replies when no ping is outstanding are ignored.
+func (c *conn) pong(sent int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.awaiting.IsZero() || sent != c.awaiting.UnixMilli() {
+		return
+	}
+	c.LastPing = time.Now()
+	c.RTT = c.LastPing.Sub(c.awaiting)
+	c.awaiting = time.Time{}
+	c.missed = 0
+}
+
+// Dropped returns the number of messages dropped for the connection
+func (c *conn) Dropped() uint64 {
+	return c.dropped.Load()
 }
 
 func (c *conn) Write(p []byte) (n int, err error) {
-	c.Messages <- p
+	c.Publish(p)
 	return len(p), nil
 }
@@ -0,0 +1,171 @@
+package fncmp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	cases := []struct {
+		name    string
+		origin  string
+		allowed []string
+		check   func(r *http.Request) bool
+		exp     bool
+	}{
+		{"no origin", "", nil, nil, true},
+		{"same origin", "http://example.com", nil, nil, true},
+		{"same origin mixed case", "http://EXAMPLE.com", nil, nil, true},
+		{"cross origin", "http://evil.com", nil, nil, false},
+		{"allowed origin", "https://app.com", []string{"https://app.com"}, nil, true},
+		{"allowed wildcard", "https://any.com", []string{"*"}, nil, true},
+		{"not in allowed", "https://other.com", []string{"https://app.com"}, nil, false},
+		{"invalid origin", "://bad", nil, nil, false},
+		{"custom check", "http://evil.com", nil, func(r *http.Request) bool { return true }, true},
+		{"custom check rejects", "http://example.com", nil, func(r *http.Request) bool { return false }, false},
+	}
+
+	allowed, check := defaultApp.config.AllowedOrigins, defaultApp.config.CheckOrigin
+	defer func() {
+		defaultApp.config.AllowedOrigins, defaultApp.config.CheckOrigin = allowed, check
+	}()
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defaultApp.config.AllowedOrigins = c.allowed
+			defaultApp.config.CheckOrigin = c.check
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			if c.origin != "" {
+				r.Header.Set("Origin", c.origin)
+			}
+			if got := defaultApp.checkOrigin(r); got != c.exp {
+				t.Errorf("expected %v, got %v", c.exp, got)
+			}
+		})
+	}
+}
+
+func TestJSONDepth(t *testing.T) {
+	cases := []struct {
+		name string
+		json string
+		exp  int
+	}{
+		{"scalar", `"a"`, 0},
+		{"flat object", `{"a":1}`, 1},
+		{"nested", `{"a":{"b":[1,[2]]}}`, 4},
+		{"brackets in string", `{"a":"{[{["}`, 1},
+		{"escaped quote", `{"a":"\"{{"}`, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := jsonDepth([]byte(c.json)); got != c.exp {
+				t.Errorf("expected %d, got %d", c.exp, got)
+			}
+		})
+	}
+}
+
+func TestDecodeDispatch(t *testing.T) {
+	cases := []struct {
+		name string
+		json string
+		err  bool
+	}{
+		{"valid", `{"function":"event","event":{"data":{"a":{"b":1}}}}`, false},
+		{"too deep", `{"function":"event","event":{"data":{"a":{"b":[1]}}}}`, true},
+		{"invalid", `{"function":`, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var d Dispatch
+			err := decodeDispatch(JSONCodec{}, 2, []byte(c.json), &d)
+			if (err != nil) != c.err {
+				t.Errorf("expected error %v, got %v", c.err, err)
+			}
+		})
+	}
+}
+
+func TestPong(t *testing.T) {
+	c := &conn{}
+	sent := time.Now().Add(-50 * time.Millisecond)
+
+	// Replies when no ping is outstanding are ignored
+	c.pong(sent.UnixMilli())
+	if !c.LastPing.IsZero() {
+		t.Fatal("expected reply without ping to be ignored")
+	}
+
+	c.awaiting, c.missed = sent, 1
+	for _, echoed := range []int64{0, sent.Add(-time.Minute).UnixMilli()} {
+		c.pong(echoed)
+		if !c.LastPing.IsZero() || c.missed != 1 {
+			t.Errorf("expected reply echoing %d to be ignored", echoed)
+		}
+	}
+
+	// RTT is measured from when the server sent the ping
+	c.pong(sent.UnixMilli())
+	if c.LastPing.IsZero() || c.missed != 0 || !c.awaiting.IsZero() {
+		t.Errorf("expected reply to be recorded, got %+v", c)
+	}
+	if c.RTT < 50*time.Millisecond || c.RTT > time.Second {
+		t.Errorf("expected RTT of about 50ms, got %v", c.RTT)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	a := NewApp(&Config{
+		Silent:         true,
+		PingInterval:   10 * time.Millisecond,
+		PongTimeout:    20 * time.Millisecond,
+		MaxMissedPings: 2,
+	})
+	server := httptest.NewServer(a.MiddleWareFn(
+		func(w http.ResponseWriter, r *http.Request) {},
+		func(ctx context.Context) FnComponent { return NewFn(ctx, HTML("<p>page</p>")) },
+	))
+	defer server.Close()
+
+	// Replies are recorded
+	ws := _test_dial(t, _test_url(a, server, "/", t.Name()+"_reply"), nil)
+	d := _test_read(t, ws, ping)
+	d.FnPing.Client = true
+	if err := ws.WriteJSON(d); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		info, _ := a.Connection(t.Name() + "_reply")
+		if !info.LastPing.IsZero() {
+			if info.RTT <= 0 || info.RTT > time.Second {
+				t.Errorf("expected RTT of the reply, got %v", info.RTT)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected reply to be recorded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Connections that miss Config.MaxMissedPings pings are closed
+	ws = _test_dial(t, _test_url(a, server, "/", t.Name()+"_silent"), nil)
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Fatal("expected connection to be closed")
+			}
+			break
+		}
+	}
+	if _, ok := a.Connection(t.Name() + "_silent"); ok {
+		t.Error("expected unresponsive connection to be removed")
+	}
+}
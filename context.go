@@ -12,16 +12,43 @@ const (
 	RequestKey ContextKey = "request"
 	// ResponseKey is used to store http.ResponseWriter in context
 	ErrorKey ContextKey = "error"
+	// ConnIDKey is used to store the connection ID in context
+	ConnIDKey ContextKey = "conn_id"
+	// HandlerIDKey is used to store the handler ID in context
+	HandlerIDKey ContextKey = "handler_id"
+	// SessionIDKey is used to store the session ID in context
+	SessionIDKey ContextKey = "session_id"
+	// PrincipalKey is used to store the authenticated principal in context
+	PrincipalKey ContextKey = "principal"
+	// boundaryKey is used internally to store the nearest error boundary
+	boundaryKey ContextKey = "__boundary__"
 	// dispatchKey is used internally to store dispatchDetails in context
 	dispatchKey ContextKey = "__dispatch__"
 )
 
 type dispatchDetails struct {
 	ConnID    string
+	SessionID string
 	Conn      *conn
 	HandlerID string
 }
 
+// storeKey returns the key of the cache store shared by the session
+func (dd dispatchDetails) storeKey() string {
+	if dd.SessionID != "" {
+		return dd.SessionID
+	}
+	return dd.ConnID
+}
+
+// app returns the App serving the connection
+func (dd dispatchDetails) app() *App {
+	if dd.Conn == nil {
+		return defaultApp
+	}
+	return orDefault(dd.Conn.app)
+}
+
 func dispatchFromContext(ctx context.Context) (dispatchDetails, bool) {
 	dd, ok := ctx.Value(dispatchKey).(dispatchDetails)
 	return dd, ok
@@ -0,0 +1,74 @@
+package fncmp
+
+import "sync"
+
+// Subsystems reported by Goroutines
+const (
+	goHandler   = "handler"   // Handler dispatch loops
+	goDispatch  = "dispatch"  // Dispatches being handled
+	goConn      = "conn"      // Connection readers and writers
+	goHeartbeat = "heartbeat" // Connection pingers
+	goScheduler = "scheduler" // The timer loop and timers being run
+	goReplay    = "replay"    // Replays to reconnected clients
+	goAck       = "ack"       // OnAck functions being run
+	goBackplane = "backplane" // SocketBackplane readers and deliveries
+)
+
+// Goroutines returns the number of live goroutines started by the default
+// App per subsystem. See App.Goroutines.
+func Goroutines() map[string]int {
+	return defaultApp.Goroutines()
+}
+
+// Goroutines returns the number of live goroutines started by the App per
+// subsystem, e.g. "conn" or "handler", to help find leaks. Subsystems
+// without goroutines are omitted.
+func (a *App) Goroutines() map[string]int {
+	return a.goroutines.counts()
+}
+
+// goroutineCounter counts live goroutines per subsystem
+type goroutineCounter struct {
+	mu    sync.Mutex
+	count map[string]int
+}
+
+// Go runs fn in a goroutine counted under subsystem
+func (g *goroutineCounter) Go(subsystem string, fn func()) {
+	done := g.track(subsystem)
+	go func() {
+		defer done()
+		fn()
+	}()
+}
+
+// track counts the calling goroutine under subsystem until done is called
+func (g *goroutineCounter) track(subsystem string) (done func()) {
+	g.add(subsystem, 1)
+	var once sync.Once
+	return func() {
+		once.Do(func() { g.add(subsystem, -1) })
+	}
+}
+
+func (g *goroutineCounter) add(subsystem string, n int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.count == nil {
+		g.count = make(map[string]int)
+	}
+	g.count[subsystem] += n
+	if g.count[subsystem] == 0 {
+		delete(g.count, subsystem)
+	}
+}
+
+func (g *goroutineCounter) counts() map[string]int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	counts := make(map[string]int, len(g.count))
+	for k, v := range g.count {
+		counts[k] = v
+	}
+	return counts
+}
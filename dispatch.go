@@ -11,8 +11,10 @@ const (
 	render   functionName = "render"
 	class    functionName = "class"
 	redirect functionName = "redirect"
+	navigate functionName = "navigate"
 	event    functionName = "event"
 	custom   functionName = "custom"
+	ack      functionName = "ack"
 	_error   functionName = "error"
 )
 
@@ -29,10 +31,16 @@ type (
 		HTML           string          `json:"html"`
 		EventListeners []EventListener `json:"event_listeners"`
 	}
+	// FnAuth is used internally to authenticate the client.
+	FnAuth struct {
+		Key   string `json:"key"`
+		Token string `json:"token"`
+	}
 	// FnPing is used internally to ping the client or server.
 	FnPing struct {
-		Server bool `json:"server"`
-		Client bool `json:"client"`
+		Server bool  `json:"server"`
+		Client bool  `json:"client"`
+		Sent   int64 `json:"sent"` // Unix milliseconds when the server sent the ping
 	}
 	// FnClass is used internally to add or remove classes from elements.
 	FnClass struct {
@@ -43,6 +51,12 @@ type (
 	// FnRedirect is used internally to redirect the client to a new URL.
 	FnRedirect struct {
 		URL string `json:"url"`
+		// Navigate tells the client to push URL to its history instead of
+		// loading it, as a Router renders it over the connection
+		Navigate bool `json:"navigate,omitempty"`
+		// Pop is set by the client when it has already moved to URL in its
+		// history, e.g. with the back button
+		Pop bool `json:"pop,omitempty"`
 	}
 	// FnCustom is used internally to run custom JavaScript on the client.
 	FnCustom struct {
@@ -55,6 +69,9 @@ type (
 	// See: https://pkg.go.dev/github.com/kitkitchen/fncmp#SetConfig
 	FnError struct {
 		Message string `json:"message"`
+		Code    string `json:"code,omitempty"`
+		// Reload tells the client to hard reload the page
+		Reload bool `json:"reload,omitempty"`
 	}
 )
 
@@ -72,13 +89,17 @@ func newDispatch(key string) *Dispatch {
 type Dispatch struct {
 	buf        []byte        `json:"-"`
 	conn       *conn         `json:"-"`
+	onAck      func()        `json:"-"`
+	eventSeq   uint64        `json:"-"` // Arrival order of an event among those of its listener
 	ID         string        `json:"id"`
+	Seq        uint64        `json:"seq,omitempty"`
 	Key        string        `json:"key"`
 	ConnID     string        `json:"conn_id"`
 	HandlerID  string        `json:"handler_id"`
 	Action     string        `json:"action"`
 	Label      string        `json:"label"`
 	Function   functionName  `json:"function"`
+	FnAuth     FnAuth        `json:"auth"`
 	FnEvent    EventListener `json:"event"`
 	FnPing     FnPing        `json:"ping"`
 	FnRender   FnRender      `json:"render"`
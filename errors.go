@@ -12,6 +12,22 @@ const (
 	ErrConnectionNotFound DispatchError = "connection not found"
 	ErrConnectionFailed   DispatchError = "connection failed"
 	ErrCtxMissingEvent    DispatchError = "context missing event"
+	ErrOriginNotAllowed   DispatchError = "origin not allowed"
+	ErrQueueClosed        DispatchError = "connection queue closed"
+	ErrPublishTimeout     DispatchError = "timed out waiting for space in connection queue"
+	ErrBackpressure       DispatchError = "connection queue full"
+	ErrShuttingDown       DispatchError = "server shutting down"
+	ErrMalformedFrame     DispatchError = "malformed frame"
+	ErrUnauthorized       DispatchError = "unauthorized"
+	ErrInvalidToken       DispatchError = "invalid connection token"
+	ErrTokenExpired       DispatchError = "connection token expired"
+	ErrProtocolVersion    DispatchError = "unsupported protocol version"
+	ErrHandlerClosed      DispatchError = "handler closed"
+	ErrHandlerNotFound    DispatchError = "handler not found"
+	ErrHandlerPanic       DispatchError = "handler panicked"
+	ErrConnectionClosed   DispatchError = "connection closed"
+	ErrEventSuperseded    DispatchError = "event superseded by a newer event"
+	ErrEventTimeout       DispatchError = "event timed out"
 )
 
 type CacheError string
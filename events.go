@@ -102,11 +102,13 @@ type EventListener struct {
 	Handler         HandleFn `json:"-"`
 	On              OnEvent  `json:"on"`
 	Data            any      `json:"data"`
+	label           string   // Label of the FnComponent, for logs
 }
 
 func newEventListener(on OnEvent, f FnComponent, h HandleFn) EventListener {
-	if f.dispatch.conn == nil {
-		config.Logger.Error("connection not found")
+	c := f.dispatch.conn
+	if c == nil {
+		defaultApp.config.Logger.Error("connection not found")
 	}
 	id := uuid.New().String()
 	el := EventListener{
@@ -115,8 +117,9 @@ func newEventListener(on OnEvent, f FnComponent, h HandleFn) EventListener {
 		TargetID: f.id,
 		Handler:  h,
 		On:       on,
+		label:    f.dispatch.Label,
 	}
-	evtListeners.Add(f.dispatch.conn, el)
+	c.app.evtListeners.Add(c, el)
 	return el
 }
 
@@ -126,10 +129,6 @@ type eventListeners struct {
 	el map[string]map[string]EventListener
 }
 
-var evtListeners = eventListeners{
-	el: make(map[string]map[string]EventListener),
-}
-
 func (e *eventListeners) Add(conn *conn, el EventListener) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -317,3 +316,60 @@ type FormDataEvent struct {
 	EventPhase       int            `json:"eventPhase"`
 	FormData         map[string]any `json:"formData"`
 }
+
+// eventRun is an event being handled
+type eventRun struct {
+	seq    uint64
+	cancel context.CancelCauseFunc
+}
+
+// nextEventSeq returns the sequence number of an event of the listener with
+// id that has just arrived
+func (c *conn) nextEventSeq(id string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.eventSeqs == nil {
+		c.eventSeqs = make(map[string]uint64)
+	}
+	c.eventSeqs[id]++
+	return c.eventSeqs[id]
+}
+
+// eventContext returns the context for the event with sequence number seq
+// of listener. It carries the values of the listener's context and is
+// cancelled when the connection closes, when a newer event of the listener
+// starts, or after Config.EventTimeout. If a newer event has already
+// started, it is returned cancelled. done must be called once the event is
+// handled.
+func (c *conn) eventContext(listener EventListener, seq uint64) (ctx context.Context, done func()) {
+	ctx, cancel := context.WithCancelCause(context.WithoutCancel(listener.Context))
+	stop := func() bool { return false }
+	if c.ctx != nil {
+		stop = context.AfterFunc(c.ctx, func() { cancel(context.Cause(c.ctx)) })
+	}
+	cancelTimeout := context.CancelFunc(func() {})
+	if timeout := c.app.config.EventTimeout; timeout > 0 {
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, timeout, ErrEventTimeout)
+	}
+
+	c.mu.Lock()
+	if c.events == nil {
+		c.events = make(map[string]*eventRun)
+	}
+	// Events may start out of order, so only older events are superseded
+	if prev, ok := c.events[listener.ID]; ok && prev.seq > seq {
+		cancel(ErrEventSuperseded)
+	} else {
+		if ok {
+			prev.cancel(ErrEventSuperseded)
+		}
+		c.events[listener.ID] = &eventRun{seq: seq, cancel: cancel}
+	}
+	c.mu.Unlock()
+
+	return ctx, func() {
+		stop()
+		cancelTimeout()
+		cancel(context.Canceled)
+	}
+}
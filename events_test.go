@@ -0,0 +1,119 @@
+package fncmp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventContext(t *testing.T) {
+	a := NewApp(&Config{Silent: true, EventTimeout: 100 * time.Millisecond})
+	causes := make(chan error, 3)
+	started := make(chan struct{}, 3)
+	wait := func(ctx context.Context) FnComponent {
+		started <- struct{}{}
+		<-ctx.Done()
+		causes <- context.Cause(ctx)
+		return NewFn(ctx, HTML("<p>cancelled</p>"))
+	}
+	searchCauses := make(chan error, 2)
+	search := func(ctx context.Context) FnComponent {
+		if data, _ := EventData[map[string]string](ctx); data["q"] == "latest" {
+			return NewFn(ctx, HTML("<p>latest</p>"))
+		}
+		<-ctx.Done()
+		searchCauses <- context.Cause(ctx)
+		return NewFn(ctx, HTML("<p>stale</p>"))
+	}
+	page := func(ctx context.Context) FnComponent {
+		return NewFn(ctx, HTML("<p>page</p>")).WithEvents(search, OnInput).WithEvents(wait, OnClick)
+	}
+	server := httptest.NewServer(a.MiddleWareFn(func(w http.ResponseWriter, r *http.Request) {}, page))
+	defer server.Close()
+	ws := _test_dial(t, _test_url(a, server, "/", t.Name()), nil)
+	d := _test_read(t, ws, render)
+	listeners := make(map[OnEvent]string)
+	for _, el := range d.FnRender.EventListeners {
+		listeners[el.On] = el.ID
+	}
+	fire := func(on OnEvent, data any) {
+		t.Helper()
+		if err := ws.WriteJSON(Dispatch{
+			Function:  event,
+			HandlerID: d.HandlerID,
+			FnEvent:   EventListener{ID: listeners[on], Data: data},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectCause := func(exp error) {
+		t.Helper()
+		select {
+		case err := <-causes:
+			if !errors.Is(err, exp) {
+				t.Errorf("expected %v, got %v", exp, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %v, got none", exp)
+		}
+	}
+
+	// A newer event of the listener cancels the previous one, whose
+	// response is dropped, in whichever order they start
+	fire(OnInput, map[string]string{"q": "stale"})
+	fire(OnInput, map[string]string{"q": "latest"})
+	if d := _test_read(t, ws, render); !strings.Contains(d.FnRender.HTML, "<p>latest</p>") {
+		t.Errorf("expected latest, got %s", d.FnRender.HTML)
+	}
+	select {
+	case err := <-searchCauses:
+		if !errors.Is(err, ErrEventSuperseded) {
+			t.Errorf("expected %v, got %v", ErrEventSuperseded, err)
+		}
+	default:
+		// The older event started last and was not handled
+	}
+
+	// Events time out after Config.EventTimeout. The next render is theirs,
+	// not that of the superseded event.
+	fire(OnClick, nil)
+	<-started
+	expectCause(ErrEventTimeout)
+	if d := _test_read(t, ws, render); !strings.Contains(d.FnRender.HTML, "<p>cancelled</p>") {
+		t.Errorf("expected cancelled, got %s", d.FnRender.HTML)
+	}
+
+	// Events are cancelled when the connection closes
+	a.config.EventTimeout = 0
+	fire(OnClick, nil)
+	<-started
+	ws.Close()
+	expectCause(ErrConnectionClosed)
+}
+
+func TestEventContextOrder(t *testing.T) {
+	c := &conn{app: NewApp(&Config{Silent: true})}
+	listener := EventListener{Context: context.Background(), ID: "el"}
+
+	// Events starting out of order only supersede older events
+	newer, doneNewer := c.eventContext(listener, 2)
+	defer doneNewer()
+	older, doneOlder := c.eventContext(listener, 1)
+	defer doneOlder()
+	if !errors.Is(context.Cause(older), ErrEventSuperseded) {
+		t.Errorf("expected older event to be superseded, got %v", context.Cause(older))
+	}
+	if newer.Err() != nil {
+		t.Errorf("expected newer event to run, got %v", context.Cause(newer))
+	}
+
+	newest, doneNewest := c.eventContext(listener, 3)
+	defer doneNewest()
+	if !errors.Is(context.Cause(newer), ErrEventSuperseded) || newest.Err() != nil {
+		t.Errorf("expected newest event to supersede newer, got %v and %v", context.Cause(newer), newest.Err())
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/snburman/fncmp
 
-go 1.21.5
+go 1.22
 
 require (
 	github.com/charmbracelet/log v0.3.1
@@ -2,19 +2,15 @@ package fncmp
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"sync"
-	"time"
 
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 )
 
-var handlers = handlerPool{
-	pool: make(map[string]handler),
-}
-
 type handlerPool struct {
 	mu   sync.Mutex
 	pool map[string]handler
@@ -39,24 +35,59 @@ func (h *handlerPool) Delete(id string) {
 	delete(h.pool, id)
 }
 
+// close stops and removes the handler with id, reporting whether it was
+// registered
+func (h *handlerPool) close(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	handler, ok := h.pool[id]
+	if ok {
+		close(handler.quit)
+		delete(h.pool, id)
+	}
+	return ok
+}
+
+// closeAll stops and removes every handler
+func (h *handlerPool) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, handler := range h.pool {
+		close(handler.quit)
+		delete(h.pool, id)
+	}
+}
+
 type HandleFn func(context.Context) FnComponent
 
 type handler struct {
 	http.Handler
+	app       *App
 	id        string
 	in        chan Dispatch
 	out       chan FnComponent
+	quit      chan struct{}
 	handlesFn map[string]HandleFn
+	// render returns the initial FnComponent for a new connection
+	render HandleFn
+	// router, if set, renders navigation between its routes
+	router *Router
+	// middleware wraps render and event callbacks, inside that of the App
+	middleware []Middleware
 }
 
-func newHandler() *handler {
+func (a *App) newHandler(hf HandleFn, mw ...Middleware) *handler {
 	handler := handler{
-		id:        uuid.New().String(),
-		in:        make(chan Dispatch, 256),
-		out:       make(chan FnComponent, 256),
-		handlesFn: make(map[string]HandleFn),
+		app:        a,
+		id:         uuid.New().String(),
+		render:     hf,
+		middleware: mw,
+		in:         make(chan Dispatch, 256),
+		out:        make(chan FnComponent, 256),
+		quit:       make(chan struct{}),
+		handlesFn:  make(map[string]HandleFn),
 	}
-	handlers.Set(handler.id, handler)
+	a.handlers.Set(handler.id, handler)
 	return &handler
 }
 
@@ -64,47 +95,133 @@ func (h handler) ID() string {
 	return h.id
 }
 
+// listen handles queued dispatches until the handler is closed
 func (h *handler) listen() {
-	go func(h *handler) {
-		for d := range h.in {
-			switch d.Function {
-			case ping:
-				go h.Ping(d)
-			case event:
-				go h.Event(d)
-			case custom:
-				go h.CustomIn(d)
-			case _error:
-				go h.Error(d)
-			default:
-				d.FnError.Message = fmt.Sprintf(
-					"function '%s' found, expected event or error on 'in' channel", d.Function)
-				go h.Error(d)
+	h.app.goroutines.Go(goHandler, func() {
+		for {
+			select {
+			case d := <-h.in:
+				h.app.goroutines.Go(goDispatch, func() {
+					defer h.app.inflight.Add(-1)
+					h.receive(d)
+				})
+			case fn := <-h.out:
+				h.app.goroutines.Go(goDispatch, func() {
+					defer h.app.inflight.Add(-1)
+					h.send(fn)
+				})
+			case <-h.quit:
+				h.drain()
+				return
 			}
 		}
-	}(h)
-	go func(h *handler) {
-		for fn := range h.out {
-			switch fn.dispatch.Function {
-			case ping:
-				go h.Ping(*fn.dispatch)
-			case render:
-				go h.Render(fn)
-			case class:
-				go h.Class(fn)
-			case redirect:
-				go h.Redirect(fn)
-			case custom:
-				go h.CustomOut(fn)
-			case _error:
-				go h.Error(*fn.dispatch)
-			default:
-				fn.dispatch.FnError.Message = fmt.Sprintf(
-					"function '%s' found, expected event or error on 'in' channel", fn.dispatch.Function)
-				go h.Error(*fn.dispatch)
-			}
+	})
+}
+
+// drain discards the dispatches still queued for a closed handler
+func (h *handler) drain() {
+	for {
+		select {
+		case <-h.in:
+		case <-h.out:
+		default:
+			return
 		}
-	}(h)
+		h.app.inflight.Add(-1)
+	}
+}
+
+// queueIn queues a dispatch from the client for the handler
+func (h handler) queueIn(d Dispatch) {
+	if h.closed() {
+		return
+	}
+	h.app.inflight.Add(1)
+	select {
+	case h.in <- d:
+	case <-h.quit:
+		h.app.inflight.Add(-1)
+		return
+	}
+	h.drainClosed()
+}
+
+// queueOut queues a FnComponent to be sent to the client by the handler
+func (h handler) queueOut(fn FnComponent) {
+	if h.closed() {
+		return
+	}
+	h.app.inflight.Add(1)
+	select {
+	case h.out <- fn:
+	case <-h.quit:
+		h.app.inflight.Add(-1)
+		return
+	}
+	h.drainClosed()
+}
+
+// closed reports whether the handler has been closed
+func (h handler) closed() bool {
+	select {
+	case <-h.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// drainClosed drains the handler if it was closed while queueing, as listen
+// may have drained it before the dispatch was queued, so that it is not left
+// counted as in flight
+func (h handler) drainClosed() {
+	if h.closed() {
+		h.drain()
+	}
+}
+
+// receive handles a dispatch from the client
+func (h handler) receive(d Dispatch) {
+	switch d.Function {
+	case ping:
+		h.Ping(d)
+	case event:
+		h.Event(d)
+	case custom:
+		h.CustomIn(d)
+	case navigate:
+		h.Navigate(d)
+	case ack:
+		h.Ack(d)
+	case _error:
+		h.Error(d)
+	default:
+		d.FnError.Message = fmt.Sprintf(
+			"function '%s' found, expected event or error on 'in' channel", d.Function)
+		h.Error(d)
+	}
+}
+
+// send handles a FnComponent to be sent to the client
+func (h handler) send(fn FnComponent) {
+	switch fn.dispatch.Function {
+	case ping:
+		h.Ping(*fn.dispatch)
+	case render:
+		h.Render(fn)
+	case class:
+		h.Class(fn)
+	case redirect:
+		h.Redirect(fn)
+	case custom:
+		h.CustomOut(fn)
+	case _error:
+		h.Error(*fn.dispatch)
+	default:
+		fn.dispatch.FnError.Message = fmt.Sprintf(
+			"function '%s' found, expected event or error on 'in' channel", fn.dispatch.Function)
+		h.Error(*fn.dispatch)
+	}
 }
 
 func (h handler) Ping(d Dispatch) {
@@ -119,6 +236,8 @@ func (h handler) Ping(d Dispatch) {
 		h.MarshalAndPublish(d)
 		return
 	}
+	// Record the client's reply
+	d.conn.pong(d.FnPing.Sent)
 }
 
 func (h handler) Render(fn FnComponent) {
@@ -145,11 +264,43 @@ func (h handler) Redirect(fn FnComponent) {
 	if fn.dispatch.FnRedirect.URL == "" {
 		return
 	}
+	// Routes of a Router are rendered without reloading the page
+	if h.router != nil && fn.dispatch.conn != nil && h.router.navigate(h, fn.dispatch.conn, fn.dispatch.FnRedirect.URL, true) {
+		return
+	}
 	h.MarshalAndPublish(*fn.dispatch)
 }
 
+// Navigate renders the route the client navigated to, or redirects the
+// client to load it if it is not a route of the handler
+func (h handler) Navigate(d Dispatch) {
+	if d.conn == nil {
+		d.FnError.Message = ErrConnectionNotFound.Error()
+		h.Error(d)
+		return
+	}
+	// Restricted connections only render Config.UnauthenticatedHandler
+	if d.conn.restricted {
+		d.FnError.Message = ErrUnauthorized.Error()
+		h.Error(d)
+		return
+	}
+	target := d.FnRedirect
+	if h.router != nil && h.router.navigate(h, d.conn, target.URL, !target.Pop) {
+		return
+	}
+	if _, ok := withPath(d.conn.page(), target.URL); !ok {
+		d.FnError.Message = fmt.Sprintf("cannot navigate to '%s'", target.URL)
+		h.Error(d)
+		return
+	}
+	d.Function = redirect
+	d.FnRedirect = FnRedirect{URL: target.URL}
+	h.MarshalAndPublish(d)
+}
+
 func (h handler) CustomIn(d Dispatch) {
-	config.Logger.Debug("custom function in", d.FnCustom.Function+" result", d.FnCustom.Result)
+	h.app.config.Logger.Debug("custom function in", d.FnCustom.Function+" result", d.FnCustom.Result)
 }
 
 func (h handler) CustomOut(fn FnComponent) {
@@ -159,19 +310,33 @@ func (h handler) CustomOut(fn FnComponent) {
 	h.MarshalAndPublish(*fn.dispatch)
 }
 
+// Ack marks a sequenced dispatch as applied by the client
+func (h handler) Ack(d Dispatch) {
+	if d.conn == nil {
+		return
+	}
+	if ob, ok := h.app.outboxes.Get(d.conn.ID); ok {
+		ob.ack(d.Seq)
+	}
+}
+
 func (h handler) MarshalAndPublish(d Dispatch) {
 	if d.conn == nil {
 		d.FnError.Message = "connection not found"
 		h.Error(d)
 		return
 	}
-	b, err := json.Marshal(d)
+	var err error
+	if d.Function == ping {
+		// Unsequenced dispatches are not replayed
+		err = d.conn.send(d)
+	} else {
+		err = h.app.outboxes.GetOrCreate(d.conn.ID).publish(d)
+	}
 	if err != nil {
 		d.FnError.Message = err.Error()
 		h.Error(d)
-		return
 	}
-	d.conn.Publish(b)
 }
 
 func (h handler) Event(d Dispatch) {
@@ -180,7 +345,7 @@ func (h handler) Event(d Dispatch) {
 		h.Error(d)
 		return
 	}
-	listener, ok := evtListeners.Get(d.FnEvent.ID, d.conn)
+	listener, ok := h.app.evtListeners.Get(d.FnEvent.ID, d.conn)
 	if !ok {
 		d.FnError.Message = fmt.Sprintf("event listener with id '%s' not found", d.FnEvent.ID)
 		h.Error(d)
@@ -188,18 +353,40 @@ func (h handler) Event(d Dispatch) {
 	}
 	listener.Data = d.FnEvent.Data
 
-	ctx := context.WithValue(listener.Context, EventKey, listener)
-	response := listener.Handler(ctx)
+	// Each event has its own context, cancelled with the connection or by a
+	// newer event of the listener
+	ctx, done := d.conn.eventContext(listener, d.eventSeq)
+	defer done()
+	if errors.Is(context.Cause(ctx), ErrEventSuperseded) {
+		return
+	}
+	// The connection may have reconnected or navigated since the listener
+	// was registered
+	ctx = d.conn.handlerContext(ctx, d.HandlerID)
+	listener.Context = ctx
+	ctx = context.WithValue(ctx, EventKey, listener)
+	response, ok := h.call(ctx, h.wrap(listener.Handler), listener.label, listener.TargetID)
+	// The response of a superseded event would overwrite that of the newer
+	// one
+	if !ok || errors.Is(context.Cause(ctx), ErrEventSuperseded) {
+		return
+	}
 	response.dispatch.conn = d.conn
 	response.dispatch.HandlerID = d.HandlerID
-	h.out <- response
+	h.queueOut(response)
 }
 
 func (h handler) Error(d Dispatch) {
-	if config.Silent {
+	// A sequenced dispatch the client failed to apply is not retried
+	if d.Seq != 0 && d.conn != nil {
+		if ob, ok := h.app.outboxes.Get(d.conn.ID); ok {
+			ob.discard(d.Seq)
+		}
+	}
+	if h.app.config.Silent {
 		return
 	}
-	config.Logger.Error(d.FnError)
+	h.app.config.Logger.Error(d.FnError)
 }
 
 type Writer struct {
@@ -212,63 +399,75 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
-func MiddleWareFn(h http.HandlerFunc, hf HandleFn) http.HandlerFunc {
-	handler := newHandler()
+// MiddleWareFn serves h with the default App. See App.MiddleWareFn.
+func MiddleWareFn(h http.HandlerFunc, hf HandleFn, mw ...Middleware) http.HandlerFunc {
+	return defaultApp.MiddleWareFn(h, hf, mw...)
+}
+
+// MiddleWareFn returns a handler that serves pages with h and the
+// connections they open with hf. The initial render and every event
+// callback are wrapped by mw. Use NewHandler for a handler that can be
+// closed.
+func (a *App) MiddleWareFn(h http.HandlerFunc, hf HandleFn, mw ...Middleware) http.HandlerFunc {
+	return a.NewHandler(h, hf, mw...).ServeHTTP
+}
+
+// Handler serves pages and the connections they open until it is closed
+type Handler struct {
+	app     *App
+	page    http.HandlerFunc
+	handler *handler
+}
+
+// NewHandler returns a Handler of the default App. See App.NewHandler.
+func NewHandler(h http.HandlerFunc, hf HandleFn, mw ...Middleware) *Handler {
+	return defaultApp.NewHandler(h, hf, mw...)
+}
+
+// NewHandler returns a Handler that serves pages with h and the connections
+// they open with hf, wrapping the initial render and event callbacks by mw
+func (a *App) NewHandler(h http.HandlerFunc, hf HandleFn, mw ...Middleware) *Handler {
+	handler := a.newHandler(hf, mw...)
 	handler.listen()
+	return &Handler{app: a, page: h, handler: handler}
+}
 
-	return func(w http.ResponseWriter, r *http.Request) {
-		id := r.URL.Query().Get("fncmp_id")
-		if id == "" {
-			writer := Writer{ResponseWriter: w}
-			h(&writer, r)
-			w.Write(writer.buf)
-			return
-		}
-		newConnection, err := newConn(w, r, handler.id, id)
-		if err != nil {
-			config.Logger.Error(ErrConnectionFailed)
-			config.Logger.Error(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(ErrConnectionFailed))
-			return
-		}
-		newConnection.HandlerID = handler.id
-
-		ctx := context.WithValue(r.Context(), dispatchKey, dispatchDetails{
-			ConnID:    id,
-			Conn:      newConnection,
-			HandlerID: handler.id,
-		})
-		ctx = context.WithValue(ctx, RequestKey, r)
-
-		// Send initial fn to client
-		fn := hf(ctx)
-		fn.dispatch.conn = newConnection
-		fn.dispatch.ConnID = id
-		fn.dispatch.HandlerID = handler.id
-		handler.out <- fn
-
-		pinger := newDispatch(id)
-		pinger.Function = ping
-		pinger.FnPing.Server = true
-		pinger.conn = newConnection
-		pinger.ConnID = id
-		pinger.HandlerID = handler.id
-
-		// Send ping to client
-		go func(d Dispatch) {
-			for {
-				// Check if connection is still open
-				conn, _ := connPool.Get(d.ConnID)
-				if conn != d.conn {
-					// Connection has been replaced
-					break
-				}
-				handler.Ping(d)
-				time.Sleep(5 * time.Second)
-			}
-		}(*pinger)
+// ID returns the handler ID sent with dispatches to the handler
+func (h *Handler) ID() string {
+	return h.handler.id
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a := h.app
+	// Shutdown closes the handlers, so it is checked first
+	if a.shuttingDown.Load() {
+		http.Error(w, ErrShuttingDown.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if _, ok := a.handlers.Get(h.handler.id); !ok {
+		http.Error(w, ErrHandlerClosed.Error(), http.StatusNotFound)
+		return
+	}
+	if !websocket.IsWebSocketUpgrade(r) {
+		a.issueConnToken(w, r)
+		writer := Writer{ResponseWriter: w}
+		h.page(&writer, r)
+		w.Write(a.injectMeta(writer.buf, h.handler.id, h.handler.router != nil))
+		return
+	}
+	a.serveConn(w, r, *h.handler)
+}
 
-		newConnection.listen()
+// Close unregisters the handler, stops its goroutines and closes every
+// connection carrying it. Closing a closed handler does nothing.
+func (h *Handler) Close() {
+	a := h.app
+	if !a.handlers.close(h.handler.id) {
+		return
+	}
+	for _, c := range a.connPool.All() {
+		if c.carries(h.handler.id) {
+			c.closeWith(websocket.CloseGoingAway, ErrHandlerClosed.Error())
+		}
 	}
 }
@@ -0,0 +1,62 @@
+package fncmp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHandlerClose(t *testing.T) {
+	a := NewApp(&Config{Silent: true, CacheTimeOut: time.Minute})
+	h := a.NewHandler(
+		func(w http.ResponseWriter, r *http.Request) {},
+		func(ctx context.Context) FnComponent { return NewFn(ctx, HTML("<p>handler</p>")) },
+	)
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	ws := _test_dial(t, _test_url(a, server, "/", t.Name()), nil)
+	_test_read(t, ws, render)
+
+	counts := a.Goroutines()
+	for _, subsystem := range []string{goHandler, goConn, goHeartbeat} {
+		if counts[subsystem] == 0 {
+			t.Errorf("expected %s goroutines, got %v", subsystem, counts)
+		}
+	}
+
+	h.Close()
+	h.Close()
+	if _, ok := a.handlers.Get(h.ID()); ok {
+		t.Error("expected handler to be unregistered")
+	}
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				t.Errorf("expected going away, got %v", err)
+			}
+			break
+		}
+	}
+	for _, subsystem := range []string{goHandler, goConn, goHeartbeat, goDispatch} {
+		_test_goroutines(t, a, subsystem, 0)
+	}
+	// The connection's state is cleaned up by a timer, not a goroutine
+	a.scheduler.mu.Lock()
+	pending := len(a.scheduler.tasks)
+	a.scheduler.mu.Unlock()
+	if pending == 0 {
+		t.Error("expected cleanup to be scheduled")
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 from closed handler, got %d", w.Code)
+	}
+}
@@ -0,0 +1,76 @@
+package fncmp
+
+import (
+	"context"
+	"sync"
+)
+
+// LifecycleFn is called when a connection changes state.
+//
+// The context carries the connection ID (ConnIDKey), handler ID (HandlerIDKey)
+// and the *http.Request that opened the connection (RequestKey).
+type LifecycleFn func(ctx context.Context)
+
+type lifecycleFns struct {
+	mu           sync.Mutex
+	onConnect    []LifecycleFn
+	onDisconnect []LifecycleFn
+	onReconnect  []LifecycleFn
+}
+
+// OnConnect sets a function to be called when a client of the default App
+// connects
+func OnConnect(fn LifecycleFn) {
+	defaultApp.OnConnect(fn)
+}
+
+// OnDisconnect sets a function to be called when a connection of the default
+// App closes
+func OnDisconnect(fn LifecycleFn) {
+	defaultApp.OnDisconnect(fn)
+}
+
+// OnReconnect sets a function to be called when a client of the default App
+// reconnects. See App.OnReconnect.
+func OnReconnect(fn LifecycleFn) {
+	defaultApp.OnReconnect(fn)
+}
+
+// OnConnect sets a function to be called when a client connects
+func (a *App) OnConnect(fn LifecycleFn) {
+	a.lifecycle.mu.Lock()
+	defer a.lifecycle.mu.Unlock()
+	a.lifecycle.onConnect = append(a.lifecycle.onConnect, fn)
+}
+
+// OnDisconnect sets a function to be called when a connection closes
+func (a *App) OnDisconnect(fn LifecycleFn) {
+	a.lifecycle.mu.Lock()
+	defer a.lifecycle.mu.Unlock()
+	a.lifecycle.onDisconnect = append(a.lifecycle.onDisconnect, fn)
+}
+
+// OnReconnect sets a function to be called when a client connects again with
+// a connection ID whose state is still held, e.g. after a network blip.
+//
+// OnConnect functions are called for the new connection as well.
+func (a *App) OnReconnect(fn LifecycleFn) {
+	a.lifecycle.mu.Lock()
+	defer a.lifecycle.mu.Unlock()
+	a.lifecycle.onReconnect = append(a.lifecycle.onReconnect, fn)
+}
+
+// call calls each function in fns with the context of c. A function that
+// panics is logged and does not stop the others.
+func (l *lifecycleFns) call(fns *[]LifecycleFn, c *conn) {
+	l.mu.Lock()
+	list := append([]LifecycleFn(nil), *fns...)
+	l.mu.Unlock()
+	if len(list) == 0 {
+		return
+	}
+	ctx := c.lifecycleContext()
+	for _, fn := range list {
+		c.app.protect("lifecycle", func() { fn(ctx) })
+	}
+}
@@ -0,0 +1,147 @@
+package fncmp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestLifecycle(t *testing.T) {
+	events := make(chan string, 8)
+	hook := func(name string) LifecycleFn {
+		return func(ctx context.Context) {
+			if ctx.Value(ConnIDKey) != t.Name() {
+				return
+			}
+			if _, ok := ctx.Value(RequestKey).(*http.Request); !ok {
+				t.Error("expected request in context")
+			}
+			events <- name
+		}
+	}
+	OnConnect(hook("connect"))
+	OnReconnect(hook("reconnect"))
+	OnDisconnect(hook("disconnect"))
+
+	server := _test_server(func(ctx context.Context) FnComponent {
+		return NewFn(ctx, HTML("<p>test</p>"))
+	})
+	defer server.Close()
+	url := _test_url(defaultApp, server, "/", t.Name())
+
+	expect := func(exp ...string) {
+		t.Helper()
+		for _, e := range exp {
+			select {
+			case got := <-events:
+				if got != e {
+					t.Errorf("expected %s, got %s", e, got)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("expected %s, got nothing", e)
+			}
+		}
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect("connect")
+	// Wait for the initial render so the connection has state to resume
+	_test_read(t, ws, render)
+	ws.Close()
+	expect("disconnect")
+
+	ws, _, err = websocket.DefaultDialer.Dial(url+"&fncmp_seq=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect("connect", "reconnect")
+	ws.Close()
+	expect("disconnect")
+}
+
+// _test_server serves hf with MiddleWareFn
+func _test_server(hf HandleFn) *httptest.Server {
+	return httptest.NewServer(MiddleWareFn(
+		func(w http.ResponseWriter, r *http.Request) {},
+		hf,
+	))
+}
+
+// _test_url returns the WebSocket URL of path, which may have a query, on
+// server for connection id of a
+func _test_url(a *App, server *httptest.Server, path, id string) string {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	token := a.signConnToken(id, time.Now().Add(time.Minute))
+	return "ws" + strings.TrimPrefix(server.URL, "http") + path + sep +
+		"fncmp_v=" + strconv.Itoa(ProtocolVersion) + "&fncmp_id=" + url.QueryEscape(token)
+}
+
+// _test_dial connects to u and closes the connection when the test ends
+func _test_dial(t *testing.T, u string, header http.Header) *websocket.Conn {
+	t.Helper()
+	ws, _, err := websocket.DefaultDialer.Dial(u, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+// _test_read reads dispatches from ws until one with function fn arrives
+func _test_read(t *testing.T, ws *websocket.Conn, fn functionName) Dispatch {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("expected %s, got %v", fn, err)
+		}
+		var d Dispatch
+		if err := json.Unmarshal(msg, &d); err != nil {
+			t.Fatal(err)
+		}
+		if d.Function == fn {
+			return d
+		}
+	}
+}
+
+// _test_click_server serves a page with a click listener that runs fn. The
+// returned function clicks it with data.
+func _test_click_server(t *testing.T, a *App, fn HandleFn) (*websocket.Conn, func(data any)) {
+	t.Helper()
+	server := httptest.NewServer(a.MiddleWareFn(
+		func(w http.ResponseWriter, r *http.Request) {},
+		func(ctx context.Context) FnComponent {
+			return NewFn(ctx, HTML("<p>page</p>")).WithEvents(fn, OnClick)
+		},
+	))
+	t.Cleanup(server.Close)
+	ws := _test_dial(t, _test_url(a, server, "/", t.Name()), nil)
+	d := _test_read(t, ws, render)
+	click := func(data any) {
+		t.Helper()
+		if err := ws.WriteJSON(Dispatch{
+			Function:  event,
+			HandlerID: d.HandlerID,
+			FnEvent:   EventListener{ID: d.FnRender.EventListeners[0].ID, Data: data},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ws, click
+}
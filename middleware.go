@@ -0,0 +1,49 @@
+package fncmp
+
+import "sync"
+
+// Middleware wraps a HandleFn, e.g. to check authentication, log or load
+// values into the context. It may return its own FnComponent, such as a
+// redirect or an error, instead of calling next.
+type Middleware func(next HandleFn) HandleFn
+
+// Chain returns hf wrapped by mw, the first Middleware being the outermost.
+// Use it to add Middleware to a single event listener:
+//
+//	f.WithEvents(fncmp.Chain(onSave, requireAdmin), fncmp.OnClick)
+func Chain(hf HandleFn, mw ...Middleware) HandleFn {
+	for i := len(mw) - 1; i >= 0; i-- {
+		hf = mw[i](hf)
+	}
+	return hf
+}
+
+type middlewares struct {
+	mu  sync.RWMutex
+	fns []Middleware
+}
+
+func (m *middlewares) all() []Middleware {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.fns
+}
+
+// Use adds Middleware to every handler of the default App. See App.Use.
+func Use(mw ...Middleware) {
+	defaultApp.Use(mw...)
+}
+
+// Use adds Middleware wrapping the initial render and the event callbacks
+// of every handler, outside the Middleware given to the handler itself.
+// Middleware added later is nested inside Middleware added earlier.
+func (a *App) Use(mw ...Middleware) {
+	a.middleware.mu.Lock()
+	defer a.middleware.mu.Unlock()
+	a.middleware.fns = append(a.middleware.fns[:len(a.middleware.fns):len(a.middleware.fns)], mw...)
+}
+
+// wrap returns hf wrapped by the Middleware of the App and of the handler
+func (h handler) wrap(hf HandleFn) HandleFn {
+	return Chain(Chain(hf, h.middleware...), h.app.middleware.all()...)
+}
@@ -0,0 +1,106 @@
+package fncmp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMiddleware(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	record := func(name string) Middleware {
+		return func(next HandleFn) HandleFn {
+			return func(ctx context.Context) FnComponent {
+				mu.Lock()
+				calls = append(calls, name)
+				mu.Unlock()
+				return next(ctx)
+			}
+		}
+	}
+	expectCalls := func(exp ...string) {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.Join(calls, ",") != strings.Join(exp, ",") {
+			t.Errorf("expected calls %v, got %v", exp, calls)
+		}
+		calls = nil
+	}
+	login := func(next HandleFn) HandleFn {
+		return func(ctx context.Context) FnComponent {
+			if Query(ctx).Get("deny") != "" {
+				return RedirectURL(ctx, "/login")
+			}
+			return next(ctx)
+		}
+	}
+
+	a := NewApp(&Config{Silent: true})
+	a.Use(record("app"))
+	page := func(ctx context.Context) FnComponent {
+		return NewFn(ctx, HTML("<p>page</p>")).WithEvents(
+			Chain(func(ctx context.Context) FnComponent {
+				return NewFn(ctx, HTML("<p>event</p>"))
+			}, record("listener")),
+			OnClick,
+		)
+	}
+	server := httptest.NewServer(a.MiddleWareFn(
+		func(w http.ResponseWriter, r *http.Request) {},
+		page, record("handler"), login,
+	))
+	defer server.Close()
+	dial := func(query string) *websocket.Conn {
+		t.Helper()
+		return _test_dial(t, _test_url(a, server, "/?"+query, t.Name()+query), nil)
+	}
+
+	// Middleware wraps the initial render and event callbacks
+	ws := dial("")
+	d := _test_read(t, ws, render)
+	expectCalls("app", "handler")
+	if err := ws.WriteJSON(Dispatch{
+		Function:  event,
+		HandlerID: d.HandlerID,
+		FnEvent:   EventListener{ID: d.FnRender.EventListeners[0].ID},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if d = _test_read(t, ws, render); !strings.Contains(d.FnRender.HTML, "<p>event</p>") {
+		t.Errorf("expected event, got %s", d.FnRender.HTML)
+	}
+	expectCalls("app", "handler", "listener")
+
+	// Middleware can short-circuit
+	ws = dial("deny=1")
+	if d = _test_read(t, ws, redirect); d.FnRedirect.URL != "/login" {
+		t.Errorf("expected redirect to /login, got %q", d.FnRedirect.URL)
+	}
+	expectCalls("app", "handler")
+}
+
+func TestChain(t *testing.T) {
+	var order []int
+	mw := func(i int) Middleware {
+		return func(next HandleFn) HandleFn {
+			return func(ctx context.Context) FnComponent {
+				order = append(order, i)
+				return next(ctx)
+			}
+		}
+	}
+	ctx := (&conn{app: defaultApp, ID: t.Name()}).withContext(context.Background())
+	Chain(func(ctx context.Context) FnComponent { return NewFn(ctx, nil) }, mw(1), mw(2), mw(3))(ctx)
+	if len(order) != 3 || order[0] != 1 || order[1] != 2 || order[2] != 3 {
+		t.Errorf("expected [1 2 3], got %v", order)
+	}
+}
@@ -0,0 +1,452 @@
+package fncmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// maxMsgpackDepth bounds nesting while decoding, before Config.MaxEventDataDepth
+// is checked against the decoded frame
+const maxMsgpackDepth = 256
+
+var errMsgpackTruncated = errors.New("msgpack: unexpected end of data")
+
+// unmarshalNumbers decodes JSON into a tree of maps, slices and scalars,
+// keeping numbers as json.Number so integers stay integers
+func unmarshalNumbers(b []byte, v *any) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// appendMsgpack appends the MessagePack encoding of a JSON tree to b
+func appendMsgpack(b []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return appendMsgpackInt(b, i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, 0xcb)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(f)), nil
+	case string:
+		return appendMsgpackString(b, v), nil
+	case []any:
+		b = appendMsgpackLen(b, len(v), 0x90, 0xdc)
+		var err error
+		for _, e := range v {
+			if b, err = appendMsgpack(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]any:
+		b = appendMsgpackLen(b, len(v), 0x80, 0xde)
+		// Sort keys so encoding is deterministic
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var err error
+		for _, k := range keys {
+			if b, err = appendMsgpack(b, k); err != nil {
+				return nil, err
+			}
+			if b, err = appendMsgpack(b, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type %T", v)
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func appendMsgpackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= math.MaxInt8:
+		return append(b, byte(i))
+	case i < 0 && i >= -32:
+		return append(b, byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		return append(b, 0xd0, byte(i))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(i))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(i))
+}
+
+// appendMsgpackLen appends the header of an array or map of n elements,
+// using the fix format below 16 elements
+func appendMsgpackLen(b []byte, n int, fix, wide byte) []byte {
+	switch {
+	case n < 16:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, wide), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, wide+1), uint32(n))
+}
+
+// msgpackMap encodes a map entry by entry, leaving out zero values as the
+// client fills them in
+type msgpackMap struct {
+	n    int
+	body []byte
+	err  error
+}
+
+func (m *msgpackMap) key(k string) {
+	m.n++
+	m.body = appendMsgpackString(m.body, k)
+}
+
+func (m *msgpackMap) string(k, v string) {
+	if v != "" {
+		m.key(k)
+		m.body = appendMsgpackString(m.body, v)
+	}
+}
+
+func (m *msgpackMap) bool(k string, v bool) {
+	if v {
+		m.key(k)
+		m.body = append(m.body, 0xc3)
+	}
+}
+
+func (m *msgpackMap) int(k string, v int64) {
+	if v != 0 {
+		m.key(k)
+		m.body = appendMsgpackInt(m.body, v)
+	}
+}
+
+func (m *msgpackMap) strings(k string, v []string) {
+	if len(v) == 0 {
+		return
+	}
+	m.key(k)
+	m.body = appendMsgpackLen(m.body, len(v), 0x90, 0xdc)
+	for _, s := range v {
+		m.body = appendMsgpackString(m.body, s)
+	}
+}
+
+// value adds v as its JSON representation would be, for values given by
+// users, e.g. event data
+func (m *msgpackMap) value(k string, v any) {
+	if v == nil || m.err != nil {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		m.err = err
+		return
+	}
+	var tree any
+	if err := unmarshalNumbers(b, &tree); err != nil {
+		m.err = err
+		return
+	}
+	m.key(k)
+	m.body, m.err = appendMsgpack(m.body, tree)
+}
+
+// object adds sub unless it is empty
+func (m *msgpackMap) object(k string, sub *msgpackMap) {
+	if sub.err != nil {
+		m.err = sub.err
+	}
+	if sub.n > 0 {
+		m.key(k)
+		m.body = sub.appendTo(m.body)
+	}
+}
+
+func (m *msgpackMap) appendTo(b []byte) []byte {
+	return append(appendMsgpackLen(b, m.n, 0x80, 0xde), m.body...)
+}
+
+// appendMsgpackDispatch appends d to b with the json names of its fields,
+// leaving out zero values, so that a frame only carries the sub-struct of
+// its function
+func appendMsgpackDispatch(b []byte, d *Dispatch) ([]byte, error) {
+	var m msgpackMap
+	m.string("id", d.ID)
+	m.int("seq", int64(d.Seq))
+	m.string("key", d.Key)
+	m.string("conn_id", d.ConnID)
+	m.string("handler_id", d.HandlerID)
+	m.string("action", d.Action)
+	m.string("label", d.Label)
+	m.string("function", string(d.Function))
+
+	var sub msgpackMap
+	sub.string("key", d.FnAuth.Key)
+	sub.string("token", d.FnAuth.Token)
+	m.object("auth", &sub)
+
+	m.object("event", msgpackListener(d.FnEvent))
+
+	sub = msgpackMap{}
+	sub.bool("server", d.FnPing.Server)
+	sub.bool("client", d.FnPing.Client)
+	sub.int("sent", d.FnPing.Sent)
+	m.object("ping", &sub)
+
+	sub = msgpackMap{}
+	r := d.FnRender
+	sub.string("target_id", r.TargetID)
+	sub.string("tag", r.Tag)
+	sub.bool("inner", r.Inner)
+	sub.bool("outer", r.Outer)
+	sub.bool("append", r.Append)
+	sub.bool("prepend", r.Prepend)
+	sub.bool("remove", r.Remove)
+	sub.string("html", r.HTML)
+	if len(r.EventListeners) > 0 {
+		sub.key("event_listeners")
+		sub.body = appendMsgpackLen(sub.body, len(r.EventListeners), 0x90, 0xdc)
+		for _, l := range r.EventListeners {
+			el := msgpackListener(l)
+			if el.err != nil {
+				return nil, el.err
+			}
+			sub.body = el.appendTo(sub.body)
+		}
+	}
+	m.object("render", &sub)
+
+	sub = msgpackMap{}
+	sub.string("target_id", d.FnClass.TargetID)
+	sub.bool("remove", d.FnClass.Remove)
+	sub.strings("names", d.FnClass.Names)
+	m.object("class", &sub)
+
+	sub = msgpackMap{}
+	sub.string("url", d.FnRedirect.URL)
+	sub.bool("navigate", d.FnRedirect.Navigate)
+	sub.bool("pop", d.FnRedirect.Pop)
+	m.object("redirect", &sub)
+
+	sub = msgpackMap{}
+	sub.string("function", d.FnCustom.Function)
+	sub.value("data", d.FnCustom.Data)
+	sub.value("result", d.FnCustom.Result)
+	m.object("custom", &sub)
+
+	sub = msgpackMap{}
+	sub.string("message", d.FnError.Message)
+	sub.string("code", d.FnError.Code)
+	sub.bool("reload", d.FnError.Reload)
+	m.object("error", &sub)
+
+	if m.err != nil {
+		return nil, m.err
+	}
+	return m.appendTo(b), nil
+}
+
+func msgpackListener(l EventListener) *msgpackMap {
+	var m msgpackMap
+	m.string("id", l.ID)
+	m.string("target_id", l.TargetID)
+	m.string("on", string(l.On))
+	m.value("data", l.Data)
+	return &m
+}
+
+// decodeMsgpack decodes MessagePack data into a JSON tree
+func decodeMsgpack(data []byte) (any, error) {
+	d := msgpackDecoder{data: data}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, errors.New("msgpack: trailing data")
+	}
+	return v, nil
+}
+
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, errMsgpackTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *msgpackDecoder) uint(n int) (uint64, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+func (d *msgpackDecoder) decode(depth int) (any, error) {
+	if depth > maxMsgpackDepth {
+		return nil, errors.New("msgpack: nesting too deep")
+	}
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xe0 == 0xa0:
+		return d.str(int(c & 0x1f))
+	case c&0xf0 == 0x90:
+		return d.array(int(c&0x0f), depth)
+	case c&0xf0 == 0x80:
+		return d.object(int(c&0x0f), depth)
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := d.uint(1 << (c - 0xcc))
+		return u, err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		n := 1 << (c - 0xd0)
+		u, err := d.uint(n)
+		if err != nil {
+			return nil, err
+		}
+		// Sign extend from n bytes
+		shift := 64 - 8*n
+		return int64(u<<shift) >> shift, nil
+	case 0xca:
+		u, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case 0xcb:
+		u, err := d.uint(8)
+		f := math.Float64frombits(u)
+		// JavaScript encodes large integers as floats
+		if f == math.Trunc(f) && math.Abs(f) <= 1<<53 {
+			return int64(f), err
+		}
+		return f, err
+	case 0xd9, 0xda, 0xdb, 0xc4, 0xc5, 0xc6:
+		// Binary data is decoded as a string
+		size := map[byte]int{0xd9: 1, 0xda: 2, 0xdb: 4, 0xc4: 1, 0xc5: 2, 0xc6: 4}[c]
+		n, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		return d.str(int(n))
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.array(int(n), depth)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.object(int(n), depth)
+	}
+	return nil, fmt.Errorf("msgpack: unsupported format 0x%x", c)
+}
+
+func (d *msgpackDecoder) str(n int) (any, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (d *msgpackDecoder) array(n int, depth int) (any, error) {
+	// Every element takes at least one byte, so a length beyond the
+	// remaining data is invalid
+	if n > len(d.data)-d.pos {
+		return nil, errMsgpackTruncated
+	}
+	a := make([]any, n)
+	for i := range a {
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		a[i] = v
+	}
+	return a, nil
+}
+
+func (d *msgpackDecoder) object(n int, depth int) (any, error) {
+	if 2*n > len(d.data)-d.pos {
+		return nil, errMsgpackTruncated
+	}
+	m := make(map[string]any, n)
+	for i := 0; i < n; i++ {
+		k, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("msgpack: map key %v is not a string", k)
+		}
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
@@ -0,0 +1,150 @@
+package fncmp
+
+import (
+	"sync"
+)
+
+// outboxPool holds the sequenced dispatches sent to each connection ID until
+// the client acknowledges them, so they can be replayed when a client
+// reconnects with the same connection token and tab.
+type outboxPool struct {
+	mu   sync.Mutex
+	app  *App
+	pool map[string]*outbox
+}
+
+func (o *outboxPool) Get(id string) (*outbox, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ob, ok := o.pool[id]
+	return ob, ok
+}
+
+// GetOrCreate returns the outbox for id, creating it if it does not exist
+func (o *outboxPool) GetOrCreate(id string) *outbox {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ob, ok := o.pool[id]
+	if !ok {
+		ob = &outbox{app: o.app, id: id}
+		o.pool[id] = ob
+	}
+	return ob
+}
+
+// Reset replaces the outbox for id with an empty one
+func (o *outboxPool) Reset(id string) *outbox {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ob := &outbox{app: o.app, id: id}
+	o.pool[id] = ob
+	return ob
+}
+
+func (o *outboxPool) Delete(id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.pool, id)
+}
+
+// outbox sequences dispatches for a connection ID and keeps those not yet
+// acknowledged so a reconnecting client can be sent what it missed.
+type outbox struct {
+	mu        sync.Mutex
+	app       *App
+	id        string
+	handlerID string
+	seq       uint64
+	entries   []Dispatch
+}
+
+// publish assigns the next sequence number to d, records it and sends it to
+// the active connection for the outbox ID.
+//
+// If no connection is active the dispatch is kept for replay.
+func (o *outbox) publish(d Dispatch) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	// Clients of the previous version never acknowledge or resume
+	if c, ok := o.app.connPool.Get(o.id); ok && c.version == legacyProtocolVersion {
+		return c.send(d)
+	}
+	o.seq++
+	d.Seq = o.seq
+	// Remember the handler so dispatches without one, e.g. from the
+	// backplane, can be acknowledged
+	if d.HandlerID == "" {
+		d.HandlerID = o.handlerID
+	} else {
+		o.handlerID = d.HandlerID
+	}
+	o.entries = append(o.entries, d)
+	if n := len(o.entries) - o.app.config.ReplayBufferSize; n > 0 {
+		o.app.config.Logger.Warn("outbox full, dropping unacknowledged dispatches", "conn_id", o.id, "dropped", n)
+		o.entries = o.entries[n:]
+	}
+	c, ok := o.app.connPool.Get(o.id)
+	if !ok {
+		return nil
+	}
+	return c.send(d)
+}
+
+// ack removes the dispatch with sequence number seq and calls its OnAck
+// function, if any.
+func (o *outbox) ack(seq uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i, d := range o.entries {
+		if d.Seq != seq {
+			continue
+		}
+		o.entries = append(o.entries[:i], o.entries[i+1:]...)
+		o.acked(d)
+		return
+	}
+}
+
+// acked calls the OnAck function of d, if any
+func (o *outbox) acked(d Dispatch) {
+	if d.onAck != nil {
+		o.app.goroutines.Go(goAck, func() { o.app.protect("OnAck", d.onAck) })
+	}
+}
+
+// discard removes the dispatch with sequence number seq without calling its
+// OnAck function, e.g. when the client failed to apply it.
+func (o *outbox) discard(seq uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i, d := range o.entries {
+		if d.Seq == seq {
+			o.entries = append(o.entries[:i], o.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// replay sends every unacknowledged dispatch to c.
+//
+// Dispatches up to and including last were applied by the client before it
+// disconnected and are treated as acknowledged.
+func (o *outbox) replay(c *conn, last uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	pending := o.entries[:0]
+	for _, d := range o.entries {
+		if d.Seq > last {
+			pending = append(pending, d)
+		} else {
+			o.acked(d)
+		}
+	}
+	o.entries = pending
+	for _, d := range o.entries {
+		if err := c.send(d); err != nil {
+			o.app.config.Logger.Error("failed to replay dispatch", "seq", d.Seq, "error", err)
+			return
+		}
+	}
+}
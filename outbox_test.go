@@ -0,0 +1,87 @@
+package fncmp
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestOutboxReplay(t *testing.T) {
+	size := defaultApp.config.ReplayBufferSize
+	defaultApp.config.ReplayBufferSize = 3
+	defer func() { defaultApp.config.ReplayBufferSize = size }()
+
+	ob := defaultApp.outboxes.Reset(t.Name())
+	defer defaultApp.outboxes.Delete(t.Name())
+
+	// Publish while disconnected
+	for i := 0; i < 5; i++ {
+		if err := ob.publish(Dispatch{Function: render}); err != nil {
+			t.Error(err)
+		}
+	}
+
+	cases := []struct {
+		name string
+		last uint64
+		exp  []uint64
+	}{
+		{"replay all kept", 0, []uint64{3, 4, 5}},
+		{"replay missed", 4, []uint64{5}},
+		{"replay none", 5, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cn := &conn{app: defaultApp, ID: t.Name(), codec: JSONCodec{}, queue: newMessageQueue(16)}
+			defaultApp.connPool.Set(cn.ID, cn)
+			defer defaultApp.connPool.Delete(cn.ID)
+
+			ob.replay(cn, c.last)
+			cn.queue.close()
+
+			var got []uint64
+			for msg, ok := cn.queue.pop(); ok; msg, ok = cn.queue.pop() {
+				var d Dispatch
+				if err := json.Unmarshal(msg.data, &d); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, d.Seq)
+			}
+			if len(got) != len(c.exp) {
+				t.Fatalf("expected %v, got %v", c.exp, got)
+			}
+			for i := range got {
+				if got[i] != c.exp[i] {
+					t.Errorf("expected %v, got %v", c.exp, got)
+				}
+			}
+		})
+	}
+}
+
+func TestOutboxAck(t *testing.T) {
+	ob := defaultApp.outboxes.Reset(t.Name())
+	defer defaultApp.outboxes.Delete(t.Name())
+
+	acked := make(chan struct{}, 2)
+	for i := 0; i < 3; i++ {
+		d := Dispatch{Function: render}
+		d.onAck = func() { acked <- struct{}{} }
+		ob.publish(d)
+	}
+
+	ob.ack(2)
+	ob.discard(3)
+	if len(ob.entries) != 1 || ob.entries[0].Seq != 1 {
+		t.Fatalf("expected only seq 1 unacknowledged, got %v", ob.entries)
+	}
+	<-acked
+
+	ob.ack(2)
+	select {
+	case <-acked:
+		t.Error("expected OnAck to be called once")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
@@ -3,14 +3,13 @@ package fncmp
 
 import (
 	"math"
+	"net/http"
 	"os"
 	"time"
 
 	"github.com/charmbracelet/log"
 )
 
-var config *Config
-
 var logOpts = log.Options{
 	ReportCaller:    true,
 	ReportTimestamp: true,
@@ -29,11 +28,46 @@ const (
 	None  LogLevel = math.MaxInt32
 )
 
-func init() {
-	config = &Config{
-		CacheTimeOut: time.Minute * 30,
-		LogLevel:     Error,
-		Logger:       log.NewWithOptions(os.Stderr, logOpts),
+const (
+	defaultReplayBufferSize  = 64
+	defaultMessageBufferSize = 16
+	defaultPublishTimeout    = 5 * time.Second
+	defaultPingInterval      = 5 * time.Second
+	defaultPongTimeout       = 10 * time.Second
+	defaultMaxMissedPings    = 3
+	defaultMaxMessageSize    = 64 << 10
+	defaultReadTimeout       = 60 * time.Second
+	defaultWriteTimeout      = 10 * time.Second
+	defaultMaxEventDataDepth = 32
+	defaultMaxMalformed      = 3
+	defaultAuthTimeout       = 10 * time.Second
+	defaultConnTokenTTL      = 24 * time.Hour
+)
+
+// defaultConfig returns the configuration of an App created without one
+func defaultConfig() *Config {
+	return &Config{
+		CacheTimeOut:       time.Minute * 30,
+		LogLevel:           Error,
+		Logger:             log.NewWithOptions(os.Stderr, logOpts),
+		ReplayBufferSize:   defaultReplayBufferSize,
+		MessageBufferSize:  defaultMessageBufferSize,
+		Backpressure:       BackpressureBlock,
+		PublishTimeout:     defaultPublishTimeout,
+		PingInterval:       defaultPingInterval,
+		PongTimeout:        defaultPongTimeout,
+		MaxMissedPings:     defaultMaxMissedPings,
+		Backplane:          NewMemoryBackplane(),
+		RateLimitPolicy:    RateLimitDrop,
+		MaxMessageSize:     defaultMaxMessageSize,
+		ReadTimeout:        defaultReadTimeout,
+		WriteTimeout:       defaultWriteTimeout,
+		MaxEventDataDepth:  defaultMaxEventDataDepth,
+		MaxMalformedFrames: defaultMaxMalformed,
+		AuthTimeout:        defaultAuthTimeout,
+		SigningKeys:        []SigningKey{newSigningKey()},
+		ConnTokenTTL:       defaultConnTokenTTL,
+		Codecs:             []Codec{JSONCodec{}},
 	}
 }
 
@@ -42,19 +76,160 @@ type Config struct {
 	CacheTimeOut time.Duration // Default cache timeout
 	LogLevel     LogLevel
 	Logger       *log.Logger
+
+	// AllowedOrigins lists origins, besides the server's own, that may open
+	// a connection, e.g. "https://example.com". Use "*" to allow any origin.
+	AllowedOrigins []string
+	// CheckOrigin, if set, replaces the default same-origin and AllowedOrigins
+	// check performed before a connection is upgraded.
+	CheckOrigin func(r *http.Request) bool
+	// ReplayBufferSize is the maximum number of unacknowledged dispatches kept
+	// per connection and replayed to a client that reconnects. When exceeded,
+	// the oldest are dropped.
+	ReplayBufferSize int
+	// MessageBufferSize is the number of outgoing messages queued per
+	// connection before Backpressure applies.
+	MessageBufferSize int
+	// Backpressure determines what happens when a connection's queue is full.
+	// Defaults to BackpressureBlock.
+	Backpressure BackpressurePolicy
+	// PublishTimeout is how long BackpressureBlock waits for space in a
+	// connection's queue before dropping the message.
+	PublishTimeout time.Duration
+	// PingInterval is how often each client is pinged.
+	PingInterval time.Duration
+	// PongTimeout is how long a client has to answer a ping before it counts
+	// as missed.
+	PongTimeout time.Duration
+	// MaxMissedPings is the number of missed pings after which a connection
+	// is closed.
+	MaxMissedPings int
+	// Backplane carries dispatches sent with DispatchTo and Broadcast between
+	// processes. Defaults to a MemoryBackplane.
+	Backplane Backplane
+	// ConnRateLimit limits inbound events per connection.
+	ConnRateLimit RateLimit
+	// ListenerRateLimit limits inbound events per event listener.
+	ListenerRateLimit RateLimit
+	// RateLimitPolicy determines what happens to events over a rate limit.
+	// Defaults to RateLimitDrop.
+	RateLimitPolicy RateLimitPolicy
+	// MaxMessageSize is the maximum size in bytes of a message from a client.
+	MaxMessageSize int64
+	// ReadTimeout closes a connection that sends nothing, including replies
+	// to pings, for this long.
+	ReadTimeout time.Duration
+	// WriteTimeout is the deadline for writing a message to a client.
+	WriteTimeout time.Duration
+	// MaxEventDataDepth is how deeply event data from a client may nest.
+	MaxEventDataDepth int
+	// MaxMalformedFrames is the number of malformed frames after which a
+	// connection is closed.
+	MaxMalformedFrames int
+	// Authenticator, if set, checks the token of every connection. Clients
+	// send the token with the handshake as the fncmp_auth query parameter or
+	// a bearer Authorization header, or in an auth dispatch once connected.
+	Authenticator Authenticator
+	// AuthTimeout is how long to wait for an auth dispatch.
+	AuthTimeout time.Duration
+	// UnauthenticatedHandler renders connections that fail to authenticate
+	// in place of the page's HandleFn. If nil they are rejected.
+	UnauthenticatedHandler HandleFn
+	// SigningKeys sign the connection tokens issued to clients. The first key
+	// signs new tokens and all keys verify, so keys can be rotated by adding
+	// a new key first and removing the old one once its tokens expire.
+	// Processes sharing clients must share keys. Defaults to a random key.
+	SigningKeys []SigningKey
+	// ConnTokenTTL is how long a connection token is valid.
+	ConnTokenTTL time.Duration
+	// Codecs a client may choose for its connection, in order of preference,
+	// e.g. []Codec{MessagePackCodec{}, JSONCodec{}}. Clients that offer none
+	// of them use JSONCodec. Defaults to JSONCodec.
+	Codecs []Codec
+	// SocketPath is the path SocketHandler is mounted at. If set, pages
+	// connect to it instead of to their own path.
+	SocketPath string
+	// EventTimeout is the deadline for handling an event, after which the
+	// context of its callback is cancelled. If zero, events have no deadline.
+	EventTimeout time.Duration
+	// Fallback renders in place of a HandleFn that panicked, unless a
+	// FnComponent declares its own with WithFallback. If nil, the panic is
+	// only logged.
+	Fallback Fallback
 }
 
+// SetConfig sets the configuration of the default App
 func SetConfig(c *Config) {
-	config = c
-	config.Set()
+	defaultApp.SetConfig(c)
 }
 
+// Set sets c as the configuration of the default App
 func (c *Config) Set() {
+	defaultApp.SetConfig(c)
+}
+
+// SetConfig sets the configuration of the App, filling in defaults for
+// unset fields
+func (a *App) SetConfig(c *Config) {
 	if c.Logger == nil {
 		c.Logger = log.NewWithOptions(os.Stderr, logOpts)
 	}
+	if c.ReplayBufferSize == 0 {
+		c.ReplayBufferSize = defaultReplayBufferSize
+	}
+	if c.MessageBufferSize == 0 {
+		c.MessageBufferSize = defaultMessageBufferSize
+	}
+	if c.Backpressure == "" {
+		c.Backpressure = BackpressureBlock
+	}
+	if c.PublishTimeout == 0 {
+		c.PublishTimeout = defaultPublishTimeout
+	}
+	if c.PingInterval == 0 {
+		c.PingInterval = defaultPingInterval
+	}
+	if c.PongTimeout == 0 {
+		c.PongTimeout = defaultPongTimeout
+	}
+	if c.MaxMissedPings == 0 {
+		c.MaxMissedPings = defaultMaxMissedPings
+	}
+	if c.Backplane == nil {
+		c.Backplane = NewMemoryBackplane()
+	}
+	if c.RateLimitPolicy == "" {
+		c.RateLimitPolicy = RateLimitDrop
+	}
+	if c.MaxMessageSize == 0 {
+		c.MaxMessageSize = defaultMaxMessageSize
+	}
+	if c.ReadTimeout == 0 {
+		c.ReadTimeout = defaultReadTimeout
+	}
+	if c.WriteTimeout == 0 {
+		c.WriteTimeout = defaultWriteTimeout
+	}
+	if c.MaxEventDataDepth == 0 {
+		c.MaxEventDataDepth = defaultMaxEventDataDepth
+	}
+	if c.MaxMalformedFrames == 0 {
+		c.MaxMalformedFrames = defaultMaxMalformed
+	}
+	if c.AuthTimeout == 0 {
+		c.AuthTimeout = defaultAuthTimeout
+	}
+	if len(c.SigningKeys) == 0 {
+		c.SigningKeys = []SigningKey{newSigningKey()}
+	}
+	if c.ConnTokenTTL == 0 {
+		c.ConnTokenTTL = defaultConnTokenTTL
+	}
+	if len(c.Codecs) == 0 {
+		c.Codecs = []Codec{JSONCodec{}}
+	}
 
-	config = c
+	a.config = c
 	if c.Silent || c.LogLevel == None {
 		c.Logger.SetLevel(log.Level(None))
 		return
@@ -63,7 +238,8 @@ func (c *Config) Set() {
 		"fncmp config set",
 		"cache_timeout", c.CacheTimeOut,
 		"log_level", c.LogLevel,
+		"backpressure", c.Backpressure,
 	)
 
-	config.Logger.SetLevel(log.Level(c.LogLevel))
+	c.Logger.SetLevel(log.Level(c.LogLevel))
 }
@@ -0,0 +1,168 @@
+package fncmp
+
+import (
+	"sync"
+	"time"
+)
+
+// BackpressurePolicy determines what happens when a message is published to a
+// connection whose outgoing queue is full because the client is not reading.
+type BackpressurePolicy string
+
+const (
+	// BackpressureBlock waits up to Config.PublishTimeout for space in the
+	// queue and drops the message if none frees up
+	BackpressureBlock BackpressurePolicy = "block"
+	// BackpressureDropOldest drops the oldest queued message
+	BackpressureDropOldest BackpressurePolicy = "drop_oldest"
+	// BackpressureCoalesce replaces a queued render to the same target with the
+	// new one, otherwise drops the oldest queued message
+	BackpressureCoalesce BackpressurePolicy = "coalesce"
+	// BackpressureDisconnect closes the connection
+	BackpressureDisconnect BackpressurePolicy = "disconnect"
+)
+
+// DroppedMessages returns the number of messages the default App dropped due
+// to backpressure since the program started
+func DroppedMessages() uint64 {
+	return defaultApp.DroppedMessages()
+}
+
+// DroppedMessages returns the number of messages dropped across all
+// connections due to backpressure
+func (a *App) DroppedMessages() uint64 {
+	return a.droppedMessages.Load()
+}
+
+// message is an encoded dispatch waiting to be written to a connection
+type message struct {
+	data []byte
+	// key identifies the render target of messages that may be coalesced
+	key string
+}
+
+// messageQueue is a bounded queue of outgoing messages for a connection
+type messageQueue struct {
+	mu     sync.Mutex
+	items  []message
+	size   int
+	closed bool
+	// ready is signalled when a message is pushed or the queue is closed
+	ready chan struct{}
+	// space is signalled when a message is popped
+	space chan struct{}
+}
+
+func newMessageQueue(size int) *messageQueue {
+	if size <= 0 {
+		size = 1
+	}
+	return &messageQueue{
+		size:  size,
+		ready: make(chan struct{}, 1),
+		space: make(chan struct{}, 1),
+	}
+}
+
+// push adds m to the queue, applying policy when the queue is full.
+//
+// It returns the number of messages dropped, which may include m itself.
+func (q *messageQueue) push(m message, policy BackpressurePolicy, timeout time.Duration) (dropped int, err error) {
+	var deadline <-chan time.Time
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return 1, ErrQueueClosed
+		}
+		if len(q.items) < q.size {
+			q.items = append(q.items, m)
+			q.mu.Unlock()
+			signal(q.ready)
+			return 0, nil
+		}
+
+		switch policy {
+		case BackpressureCoalesce, BackpressureDropOldest:
+			i := 0
+			if policy == BackpressureCoalesce {
+				// Remove the superseded render so order by sequence is preserved
+				i = max(q.index(m.key), 0)
+			}
+			q.items = append(append(q.items[:i], q.items[i+1:]...), m)
+			q.mu.Unlock()
+			signal(q.ready)
+			return 1, nil
+		case BackpressureDisconnect:
+			q.mu.Unlock()
+			return 1, ErrBackpressure
+		}
+
+		// BackpressureBlock
+		q.mu.Unlock()
+		if deadline == nil {
+			deadline = time.After(timeout)
+		}
+		select {
+		case <-q.space:
+		case <-deadline:
+			return 1, ErrPublishTimeout
+		}
+	}
+}
+
+// index returns the position of the queued message with key, or -1
+func (q *messageQueue) index(key string) int {
+	if key == "" {
+		return -1
+	}
+	for i, m := range q.items {
+		if m.key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// pop blocks until a message is available and returns it. It returns false
+// once the queue is closed and empty.
+func (q *messageQueue) pop() (message, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			m := q.items[0]
+			q.items = q.items[1:]
+			q.mu.Unlock()
+			signal(q.space)
+			return m, true
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return message{}, false
+		}
+		q.mu.Unlock()
+		<-q.ready
+	}
+}
+
+// close stops the queue from accepting messages
+func (q *messageQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	signal(q.ready)
+}
+
+func (q *messageQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// signal notifies a waiter on ch without blocking
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,75 @@
+package fncmp
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMessageQueuePush(t *testing.T) {
+	cases := []struct {
+		name    string
+		policy  BackpressurePolicy
+		push    message
+		dropped int
+		err     error
+		exp     []string
+	}{
+		{"block times out", BackpressureBlock, message{data: []byte("c")}, 1, ErrPublishTimeout, []string{"a", "b"}},
+		{"drop oldest", BackpressureDropOldest, message{data: []byte("c")}, 1, nil, []string{"b", "c"}},
+		{"coalesce same target", BackpressureCoalesce, message{data: []byte("c"), key: "main"}, 1, nil, []string{"a", "c"}},
+		{"coalesce other target", BackpressureCoalesce, message{data: []byte("c"), key: "other"}, 1, nil, []string{"b", "c"}},
+		{"disconnect", BackpressureDisconnect, message{data: []byte("c")}, 1, ErrBackpressure, []string{"a", "b"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			q := newMessageQueue(2)
+			q.push(message{data: []byte("a")}, c.policy, 0)
+			q.push(message{data: []byte("b"), key: "main"}, c.policy, 0)
+
+			dropped, err := q.push(c.push, c.policy, time.Millisecond)
+			if dropped != c.dropped {
+				t.Errorf("expected %d dropped, got %d", c.dropped, dropped)
+			}
+			if !errors.Is(err, c.err) {
+				t.Errorf("expected %v, got %v", c.err, err)
+			}
+
+			q.close()
+			var got []string
+			for m, ok := q.pop(); ok; m, ok = q.pop() {
+				got = append(got, string(m.data))
+			}
+			if len(got) != len(c.exp) {
+				t.Fatalf("expected %v, got %v", c.exp, got)
+			}
+			for i := range got {
+				if got[i] != c.exp[i] {
+					t.Errorf("expected %v, got %v", c.exp, got)
+				}
+			}
+		})
+	}
+}
+
+func TestMessageQueueBlock(t *testing.T) {
+	q := newMessageQueue(1)
+	q.push(message{data: []byte("a")}, BackpressureBlock, 0)
+
+	done := make(chan error)
+	go func() {
+		_, err := q.push(message{data: []byte("b")}, BackpressureBlock, time.Second)
+		done <- err
+	}()
+
+	if m, _ := q.pop(); string(m.data) != "a" {
+		t.Errorf("expected a, got %s", m.data)
+	}
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if m, _ := q.pop(); string(m.data) != "b" {
+		t.Errorf("expected b, got %s", m.data)
+	}
+}
@@ -0,0 +1,172 @@
+package fncmp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// RateLimit configures a token bucket allowing Rate events per second with
+// bursts of up to Burst events. A zero Rate means no limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitPolicy determines what happens to inbound events from a client
+// that exceeds its rate limit.
+type RateLimitPolicy string
+
+const (
+	// RateLimitDrop drops events over the limit
+	RateLimitDrop RateLimitPolicy = "drop"
+	// RateLimitCoalesce keeps only the latest event per listener over the
+	// limit and delivers it once the limit allows
+	RateLimitCoalesce RateLimitPolicy = "coalesce"
+	// RateLimitDisconnect closes the connection
+	RateLimitDisconnect RateLimitPolicy = "disconnect"
+)
+
+// DroppedEvents returns the number of inbound events the default App dropped
+// due to rate limits since the program started
+func DroppedEvents() uint64 {
+	return defaultApp.DroppedEvents()
+}
+
+// DroppedEvents returns the number of inbound events dropped across all
+// connections due to rate limits, including coalesced events replaced by a
+// later one
+func (a *App) DroppedEvents() uint64 {
+	return a.droppedEvents.Load()
+}
+
+// tokenBucket is a token bucket rate limiter. A nil tokenBucket allows
+// everything.
+type tokenBucket struct {
+	mu     sync.Mutex
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(l RateLimit) *tokenBucket {
+	if l.Rate <= 0 {
+		return nil
+	}
+	if l.Burst < 1 {
+		l.Burst = 1
+	}
+	return &tokenBucket{
+		limit:  l,
+		tokens: float64(l.Burst),
+		last:   time.Now(),
+	}
+}
+
+// take removes a token if one is available at now. Otherwise it returns how
+// long until one will be.
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	if b == nil {
+		return true, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+		b.tokens = min(b.tokens, float64(b.limit.Burst))
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// listenerLimit rate limits events for a single event listener
+type listenerLimit struct {
+	bucket  *tokenBucket
+	pending *Dispatch
+	timer   *task
+}
+
+// allow reports whether an inbound dispatch is within the connection's and
+// its listener's rate limits, applying Config.RateLimitPolicy if not.
+func (c *conn) allow(d Dispatch, h handler) bool {
+	switch d.Function {
+	case ping, ack:
+		return true
+	}
+	now := time.Now()
+	ok, wait := c.limiter.take(now)
+	var ll *listenerLimit
+	if ok && d.Function == event {
+		ll = c.listenerLimit(d.FnEvent.ID)
+		ok, wait = ll.bucket.take(now)
+	}
+	if ok {
+		return true
+	}
+
+	config := c.app.config
+	switch config.RateLimitPolicy {
+	case RateLimitDisconnect:
+		config.Logger.Warn("rate limit exceeded, disconnecting", "conn_id", c.ID, "listener_id", d.FnEvent.ID)
+		c.closeWith(websocket.ClosePolicyViolation, "rate limit exceeded")
+	case RateLimitCoalesce:
+		if ll == nil {
+			c.app.droppedEvents.Add(1)
+			config.Logger.Debug("rate limit exceeded, dropping", "conn_id", c.ID, "function", d.Function)
+			return false
+		}
+		config.Logger.Debug("rate limit exceeded, coalescing", "conn_id", c.ID, "listener_id", d.FnEvent.ID)
+		c.mu.Lock()
+		if ll.pending != nil {
+			c.app.droppedEvents.Add(1)
+		}
+		ll.pending = &d
+		if ll.timer == nil {
+			ll.timer = c.app.scheduler.After(wait, func() { c.flush(ll, h) })
+		}
+		c.mu.Unlock()
+	default:
+		c.app.droppedEvents.Add(1)
+		config.Logger.Debug("rate limit exceeded, dropping", "conn_id", c.ID, "listener_id", d.FnEvent.ID)
+	}
+	return false
+}
+
+// listenerLimit returns the rate limit state for the listener with id
+func (c *conn) listenerLimit(id string) *listenerLimit {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.listenerLimits == nil {
+		c.listenerLimits = make(map[string]*listenerLimit)
+	}
+	ll, ok := c.listenerLimits[id]
+	if !ok {
+		ll = &listenerLimit{bucket: newTokenBucket(c.app.config.ListenerRateLimit)}
+		c.listenerLimits[id] = ll
+	}
+	return ll
+}
+
+// flush delivers the latest coalesced event for a listener once its rate
+// limit allows
+func (c *conn) flush(ll *listenerLimit, h handler) {
+	ok, wait := ll.bucket.take(time.Now())
+	c.mu.Lock()
+	if !ok {
+		ll.timer = c.app.scheduler.After(wait, func() { c.flush(ll, h) })
+		c.mu.Unlock()
+		return
+	}
+	d := ll.pending
+	ll.pending, ll.timer = nil, nil
+	// queueIn may block on a full queue
+	c.mu.Unlock()
+	if d != nil {
+		h.queueIn(*d)
+	}
+}
@@ -0,0 +1,116 @@
+package fncmp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(RateLimit{Rate: 10, Burst: 3})
+	now := b.last
+
+	cases := []struct {
+		name  string
+		after time.Duration
+		ok    bool
+		wait  time.Duration
+	}{
+		{"burst 1", 0, true, 0},
+		{"burst 2", 0, true, 0},
+		{"burst 3", 0, true, 0},
+		{"burst exhausted", 0, false, 100 * time.Millisecond},
+		{"half refilled", 50 * time.Millisecond, false, 50 * time.Millisecond},
+		{"refilled", 50 * time.Millisecond, true, 0},
+		{"capped at burst", 10 * time.Second, true, 0},
+	}
+
+	for _, c := range cases {
+		now = now.Add(c.after)
+		ok, wait := b.take(now)
+		if ok != c.ok {
+			t.Errorf("%s: expected %v, got %v", c.name, c.ok, ok)
+		}
+		if (wait - c.wait).Abs() > time.Millisecond {
+			t.Errorf("%s: expected wait %v, got %v", c.name, c.wait, wait)
+		}
+	}
+	if b.tokens != 2 {
+		t.Errorf("expected 2 tokens left, got %v", b.tokens)
+	}
+
+	unlimited := newTokenBucket(RateLimit{})
+	if ok, _ := unlimited.take(now); !ok {
+		t.Error("expected zero rate to be unlimited")
+	}
+}
+
+func TestRateLimitPolicy(t *testing.T) {
+	cases := []struct {
+		policy  RateLimitPolicy
+		calls   []string
+		dropped uint64
+	}{
+		{RateLimitDrop, []string{"1"}, 2},
+		{RateLimitCoalesce, []string{"1", "3"}, 1},
+		{RateLimitDisconnect, nil, 0},
+	}
+	for _, c := range cases {
+		t.Run(string(c.policy), func(t *testing.T) {
+			a := NewApp(&Config{
+				Silent:            true,
+				ListenerRateLimit: RateLimit{Rate: 20, Burst: 1},
+				RateLimitPolicy:   c.policy,
+			})
+			calls := make(chan string, 3)
+			ws, click := _test_click_server(t, a, func(ctx context.Context) FnComponent {
+				data, _ := EventData[string](ctx)
+				calls <- data
+				return NewFn(ctx, HTML("<p>"+data+"</p>"))
+			})
+			click("1")
+			click("2")
+			click("3")
+
+			// Queued events are discarded with the connection
+			if c.policy == RateLimitDisconnect {
+				for {
+					if _, _, err := ws.ReadMessage(); err != nil {
+						if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+							t.Errorf("expected policy violation, got %v", err)
+						}
+						break
+					}
+				}
+				if _, ok := a.Connection(t.Name()); ok {
+					t.Error("expected connection to be closed")
+				}
+				return
+			}
+
+			for _, exp := range c.calls {
+				select {
+				case got := <-calls:
+					if got != exp {
+						t.Errorf("expected event %s, got %s", exp, got)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("expected event %s", exp)
+				}
+			}
+			select {
+			case got := <-calls:
+				t.Errorf("expected no more events, got %s", got)
+			case <-time.After(100 * time.Millisecond):
+			}
+			if n := a.DroppedEvents(); n != c.dropped {
+				t.Errorf("expected %d dropped events, got %d", c.dropped, n)
+			}
+			if _, ok := a.Connection(t.Name()); !ok {
+				t.Error("expected connection to be kept")
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package fncmp
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
+// Fallback returns the FnComponent rendered in place of a HandleFn that
+// panicked. err wraps ErrHandlerPanic and the panic value.
+type Fallback func(ctx context.Context, err error) FnComponent
+
+// boundary is the error boundary declared by a FnComponent with WithFallback
+type boundary struct {
+	fallback Fallback
+	target   string // ID of the FnComponent
+}
+
+// WithFallback makes f an error boundary: if an event callback of f, or of a
+// FnComponent created with f as its context, panics, fallback is rendered
+// in place of the contents of f. The nearest boundary takes precedence over
+// Config.Fallback.
+func (f FnComponent) WithFallback(fallback Fallback) FnComponent {
+	f.Context = context.WithValue(f.Context, boundaryKey, boundary{fallback: fallback, target: f.id})
+	f.updateListeners(func(el *EventListener) {
+		el.Context = context.WithValue(el.Context, boundaryKey, boundary{fallback: fallback, target: f.id})
+	})
+	return f
+}
+
+// call returns hf(ctx). If hf panics, the panic is logged with its stack
+// and label, and the fallback of the nearest error boundary or
+// Config.Fallback is returned instead, rendered into target if set. It
+// returns false if there is nothing to render.
+func (h handler) call(ctx context.Context, hf HandleFn, label, target string) (fn FnComponent, ok bool) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		err := fmt.Errorf("%w: %v", ErrHandlerPanic, r)
+		if !h.app.config.Silent {
+			h.app.config.Logger.Error(ErrHandlerPanic,
+				"label", label, "handler_id", h.id, "panic", r, "stack", string(debug.Stack()))
+		}
+		fallback := h.app.config.Fallback
+		if b, found := ctx.Value(boundaryKey).(boundary); found {
+			fallback, target = b.fallback, b.target
+		}
+		fn, ok = h.fallback(ctx, fallback, err, target)
+	}()
+	return hf(ctx), true
+}
+
+// protect calls fn, which has nothing to render in its place, logging a panic
+// with its stack and label instead of crashing the process
+func (a *App) protect(label string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil && !a.config.Silent {
+			a.config.Logger.Error(ErrHandlerPanic, "label", label, "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+	fn()
+}
+
+// fallback returns the FnComponent of fallback for err, rendered into
+// target if set
+func (h handler) fallback(ctx context.Context, fallback Fallback, err error, target string) (fn FnComponent, ok bool) {
+	if fallback == nil {
+		return fn, false
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if !h.app.config.Silent {
+				h.app.config.Logger.Error("fallback panicked", "handler_id", h.id, "panic", r, "error", err)
+			}
+			ok = false
+		}
+	}()
+	fn = fallback(ctx, err)
+	if target != "" {
+		fn = fn.SwapElementInner(target)
+	}
+	return fn, true
+}
@@ -0,0 +1,139 @@
+package fncmp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRecover(t *testing.T) {
+	fallback := func(name string) Fallback {
+		return func(ctx context.Context, err error) FnComponent {
+			if !errors.Is(err, ErrHandlerPanic) || !strings.Contains(err.Error(), "boom") {
+				t.Errorf("expected %v wrapping boom, got %v", ErrHandlerPanic, err)
+			}
+			return NewFn(ctx, HTML("<p>"+name+"</p>"))
+		}
+	}
+	a := NewApp(&Config{Silent: true, Fallback: fallback("config")})
+	boom := func(ctx context.Context) FnComponent { panic("boom") }
+	ok := func(ctx context.Context) FnComponent { return NewFn(ctx, HTML("<p>ok</p>")) }
+	page := func(ctx context.Context) FnComponent {
+		if Query(ctx).Get("panic") != "" {
+			boom(ctx)
+		}
+		f := NewFn(ctx, HTML("<p>page</p>")).WithEvents(boom, OnClick).WithEvents(ok, OnSubmit)
+		boundary := NewFn(f, nil).WithEvents(boom, OnFocus).WithFallback(fallback("boundary"))
+		NewFn(boundary, nil).WithEvents(boom, OnInput)
+		return f
+	}
+	server := httptest.NewServer(a.MiddleWareFn(func(w http.ResponseWriter, r *http.Request) {}, page))
+	defer server.Close()
+	dial := func(query string) *websocket.Conn {
+		t.Helper()
+		return _test_dial(t, _test_url(a, server, "/?"+query, t.Name()+query), nil)
+	}
+
+	// A panicking initial render renders Config.Fallback
+	d := _test_read(t, dial("panic=1"), render)
+	if !strings.Contains(d.FnRender.HTML, "<p>config</p>") || d.FnRender.Tag != "main" {
+		t.Errorf("expected config fallback in main, got %+v", d.FnRender)
+	}
+
+	ws := dial("")
+	d = _test_read(t, ws, render)
+	handlerID := d.HandlerID
+	listeners := make(map[OnEvent]EventListener)
+	a.evtListeners.mu.Lock()
+	for _, el := range a.evtListeners.el[t.Name()] {
+		listeners[el.On] = el
+	}
+	a.evtListeners.mu.Unlock()
+	if len(listeners) != 4 {
+		t.Fatalf("expected 4 event listeners, got %d", len(listeners))
+	}
+	fire := func(on OnEvent) Dispatch {
+		t.Helper()
+		if err := ws.WriteJSON(Dispatch{
+			Function:  event,
+			HandlerID: handlerID,
+			FnEvent:   EventListener{ID: listeners[on].ID},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return _test_read(t, ws, render)
+	}
+
+	// Panicking event callbacks render into the component of the listener,
+	// or of the nearest boundary
+	tests := []struct {
+		on     OnEvent
+		html   string
+		target string
+	}{
+		{OnClick, "<p>config</p>", listeners[OnClick].TargetID},
+		{OnFocus, "<p>boundary</p>", listeners[OnFocus].TargetID},
+		{OnInput, "<p>boundary</p>", listeners[OnFocus].TargetID},
+		{OnSubmit, "<p>ok</p>", ""},
+	}
+	for _, tt := range tests {
+		d := fire(tt.on)
+		if !strings.Contains(d.FnRender.HTML, tt.html) {
+			t.Errorf("%s: expected %s, got %s", tt.on, tt.html, d.FnRender.HTML)
+		}
+		if tt.target != "" && (d.FnRender.TargetID != tt.target || !d.FnRender.Inner) {
+			t.Errorf("%s: expected inner of %s, got %+v", tt.on, tt.target, d.FnRender)
+		}
+	}
+}
+
+func TestRecoverCallbacks(t *testing.T) {
+	a := NewApp(&Config{Silent: true})
+	connected := make(chan struct{}, 1)
+	a.OnConnect(func(ctx context.Context) { panic("boom") })
+	a.OnConnect(func(ctx context.Context) { connected <- struct{}{} })
+	server := httptest.NewServer(a.MiddleWareFn(
+		func(w http.ResponseWriter, r *http.Request) {},
+		func(ctx context.Context) FnComponent {
+			return NewFn(ctx, HTML("<p>test</p>")).OnAck(func() { panic("boom") })
+		},
+	))
+	defer server.Close()
+
+	// A panicking LifecycleFn does not stop the others or the connection
+	ws := _test_dial(t, _test_url(a, server, "/", t.Name()), nil)
+	d := _test_read(t, ws, render)
+	select {
+	case <-connected:
+	case <-time.After(time.Second):
+		t.Fatal("expected second OnConnect function to be called")
+	}
+
+	// A panicking OnAck function does not crash the process
+	if err := ws.WriteJSON(Dispatch{Function: ack, HandlerID: d.HandlerID, Seq: d.Seq}); err != nil {
+		t.Fatal(err)
+	}
+	acked := func() bool {
+		ob, _ := a.outboxes.Get(t.Name())
+		ob.mu.Lock()
+		defer ob.mu.Unlock()
+		return len(ob.entries) == 0
+	}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if acked() && a.Goroutines()[goAck] == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected dispatch to be acknowledged")
+		}
+	}
+	if _, ok := a.connPool.Get(t.Name()); !ok {
+		t.Error("expected connection to stay open")
+	}
+}
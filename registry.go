@@ -0,0 +1,93 @@
+package fncmp
+
+import (
+	"sort"
+	"time"
+)
+
+// ConnInfo is a snapshot of an active connection
+type ConnInfo struct {
+	ID          string
+	SessionID   string
+	HandlerID   string   // Primary handler
+	HandlerIDs  []string // Every handler carried by the connection
+	RemoteAddr  string
+	ConnectedAt time.Time
+	LastPing    time.Time     // When the client last answered a ping
+	RTT         time.Duration // Round-trip time of the last ping
+	QueueDepth  int           // Messages waiting to be written to the client
+	Dropped     uint64        // Messages dropped due to backpressure
+}
+
+// Connections returns a snapshot of every active connection of the default
+// App, oldest first
+func Connections() []ConnInfo {
+	return defaultApp.Connections()
+}
+
+// Connection returns a snapshot of the active connection of the default App
+// with id
+func Connection(id string) (ConnInfo, bool) {
+	return defaultApp.Connection(id)
+}
+
+// ConnectionCounts returns the number of active connections of the default
+// App per handler ID
+func ConnectionCounts() map[string]int {
+	return defaultApp.ConnectionCounts()
+}
+
+// Connections returns a snapshot of every active connection, oldest first
+func (a *App) Connections() []ConnInfo {
+	all := a.connPool.All()
+	infos := make([]ConnInfo, 0, len(all))
+	for _, c := range all {
+		infos = append(infos, c.info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ConnectedAt.Before(infos[j].ConnectedAt)
+	})
+	return infos
+}
+
+// Connection returns a snapshot of the active connection with id
+func (a *App) Connection(id string) (ConnInfo, bool) {
+	c, ok := a.connPool.Get(id)
+	if !ok {
+		return ConnInfo{}, false
+	}
+	return c.info(), true
+}
+
+// ConnectionCounts returns the number of active connections per handler ID
+func (a *App) ConnectionCounts() map[string]int {
+	counts := make(map[string]int)
+	for _, c := range a.connPool.All() {
+		for _, id := range c.handlerIDs() {
+			counts[id]++
+		}
+	}
+	return counts
+}
+
+func (c *conn) info() ConnInfo {
+	info := ConnInfo{
+		ID:          c.ID,
+		SessionID:   c.Session,
+		HandlerID:   c.HandlerID,
+		HandlerIDs:  c.handlerIDs(),
+		ConnectedAt: c.connected,
+		Dropped:     c.Dropped(),
+	}
+	if r := c.page(); r != nil {
+		info.RemoteAddr = r.RemoteAddr
+	}
+	if c.queue != nil {
+		info.QueueDepth = c.queue.len()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info.LastPing = c.LastPing
+	info.RTT = c.RTT
+	return info
+}
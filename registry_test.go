@@ -0,0 +1,44 @@
+package fncmp
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConnections(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	for i, id := range []string{"registry_a", "registry_b"} {
+		c := &conn{
+			ID:        id,
+			HandlerID: "registry_handler",
+			request:   r,
+			connected: time.Now().Add(time.Duration(i) * time.Second),
+			queue:     newMessageQueue(4),
+			RTT:       time.Millisecond,
+		}
+		c.queue.push(message{data: []byte("test")}, BackpressureBlock, 0)
+		defaultApp.connPool.Set(id, c)
+		defer defaultApp.connPool.Delete(id)
+	}
+
+	var infos []ConnInfo
+	for _, info := range Connections() {
+		if info.HandlerID == "registry_handler" {
+			infos = append(infos, info)
+		}
+	}
+	if len(infos) != 2 || infos[0].ID != "registry_a" || infos[1].ID != "registry_b" {
+		t.Fatalf("expected registry_a and registry_b, got %v", infos)
+	}
+	if infos[0].QueueDepth != 1 || infos[0].RTT != time.Millisecond || infos[0].RemoteAddr != r.RemoteAddr {
+		t.Errorf("unexpected info %+v", infos[0])
+	}
+
+	if _, ok := Connection("registry_a"); !ok {
+		t.Error("expected registry_a to be found")
+	}
+	if n := ConnectionCounts()["registry_handler"]; n != 2 {
+		t.Errorf("expected 2, got %d", n)
+	}
+}
@@ -0,0 +1,65 @@
+package fncmp
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Request returns the request for the page the client is on. It is the same
+// in the initial render and in event callbacks, and follows Router
+// navigation.
+func Request(ctx context.Context) (*http.Request, bool) {
+	if dd, ok := dispatchFromContext(ctx); ok && dd.Conn != nil {
+		if r := dd.Conn.page(); r != nil {
+			return r, true
+		}
+	}
+	r, ok := ctx.Value(RequestKey).(*http.Request)
+	return r, ok && r != nil
+}
+
+// PathParam returns the value of the wildcard name in the pattern matching
+// the page, e.g. "42" for the "id" of "/users/{id}" at "/users/42".
+//
+// Patterns are those of the http.ServeMux serving MiddleWareFn or of a
+// Router. Pages rendered through SocketHandler only have the parameters of
+// Router routes.
+func PathParam(ctx context.Context, name string) string {
+	r, ok := Request(ctx)
+	if !ok {
+		return ""
+	}
+	return r.PathValue(name)
+}
+
+// Query returns the query parameters of the page
+func Query(ctx context.Context) url.Values {
+	r, ok := Request(ctx)
+	if !ok {
+		return url.Values{}
+	}
+	return r.URL.Query()
+}
+
+// Cookie returns the named cookie sent with the request for the page, or
+// http.ErrNoCookie if there is none
+func Cookie(ctx context.Context, name string) (*http.Cookie, error) {
+	r, ok := Request(ctx)
+	if !ok {
+		return nil, http.ErrNoCookie
+	}
+	return r.Cookie(name)
+}
+
+// ConnID returns the ID of the connection
+func ConnID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(ConnIDKey).(string)
+	return id, ok
+}
+
+// HandlerID returns the ID of the handler serving the connection
+func HandlerID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(HandlerIDKey).(string)
+	return id, ok
+}
@@ -0,0 +1,108 @@
+package fncmp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRequest(t *testing.T) {
+	a := NewApp(&Config{Silent: true})
+	describe := func(ctx context.Context) string {
+		r, _ := Request(ctx)
+		connID, _ := ConnID(ctx)
+		handlerID, _ := HandlerID(ctx)
+		cookie, _ := Cookie(ctx, "theme")
+		return fmt.Sprintf("<p>%s %s %s %s %s %s</p>",
+			r.URL.Path, PathParam(ctx, "id"), Query(ctx).Encode(), cookie.Value, connID, handlerID)
+	}
+	user := func(ctx context.Context) FnComponent {
+		return NewFn(ctx, HTML(describe(ctx))).WithEvents(func(ctx context.Context) FnComponent {
+			return NewFn(ctx, HTML(describe(ctx)))
+		}, OnClick)
+	}
+	h := a.NewHandler(func(w http.ResponseWriter, r *http.Request) {}, user)
+	defer h.Close()
+	router := a.NewRouter(func(w http.ResponseWriter, r *http.Request) {})
+	defer router.Close()
+	router.Handle("/posts/{id}", user)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /users/{id}", h)
+	mux.Handle("/posts/", router)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// Clients connect to the page's path and send the page with its query
+	dial := func(page, id string) *websocket.Conn {
+		t.Helper()
+		path, _, _ := strings.Cut(page, "?")
+		u := _test_url(a, server, path, id) + "&fncmp_auth=secret&fncmp_path=" + url.QueryEscape(page)
+		return _test_dial(t, u, http.Header{"Cookie": {"theme=dark"}})
+	}
+	expect := func(d Dispatch, exp string) {
+		t.Helper()
+		if !strings.Contains(d.FnRender.HTML, exp) {
+			t.Errorf("expected %s, got %s", exp, d.FnRender.HTML)
+		}
+	}
+	click := func(ws *websocket.Conn, handlerID string, d Dispatch) Dispatch {
+		t.Helper()
+		if len(d.FnRender.EventListeners) != 1 {
+			t.Fatalf("expected one event listener, got %d", len(d.FnRender.EventListeners))
+		}
+		if err := ws.WriteJSON(Dispatch{
+			Function:  event,
+			HandlerID: handlerID,
+			FnEvent:   EventListener{ID: d.FnRender.EventListeners[0].ID},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return _test_read(t, ws, render)
+	}
+
+	// ServeMux patterns in the initial render and event callbacks
+	ws := dial("/users/42?tab=posts", "conn-mux")
+	exp := "<p>/users/42 42 tab=posts dark conn-mux " + h.ID() + "</p>"
+	d := _test_read(t, ws, render)
+	expect(d, exp)
+	expect(click(ws, h.ID(), d), exp)
+
+	// Clients without fncmp_path get the handshake's query, without the
+	// library's parameters
+	ws = _test_dial(t, _test_url(a, server, "/users/42?tab=posts&fncmp_auth=secret", "conn-raw"), http.Header{"Cookie": {"theme=dark"}})
+	expect(_test_read(t, ws, render), "<p>/users/42 42 tab=posts dark conn-raw ")
+
+	// Router routes, also after navigation
+	ws = dial("/posts/7?tab=new", "conn-router")
+	d = _test_read(t, ws, render)
+	expect(d, "<p>/posts/7 7 tab=new dark conn-router "+router.ID()+"</p>")
+	if err := ws.WriteJSON(Dispatch{
+		Function:   navigate,
+		HandlerID:  router.ID(),
+		FnRedirect: FnRedirect{URL: "/posts/8?fncmp_id=forged", Pop: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	d = _test_read(t, ws, render)
+	exp = "<p>/posts/8 8  dark conn-router " + router.ID() + "</p>"
+	expect(d, exp)
+	expect(click(ws, router.ID(), d), exp)
+
+	// Without a connection
+	if _, ok := Request(context.Background()); ok {
+		t.Error("expected no request")
+	}
+	if _, err := Cookie(context.Background(), "theme"); err != http.ErrNoCookie {
+		t.Errorf("expected %v, got %v", http.ErrNoCookie, err)
+	}
+	if PathParam(context.Background(), "id") != "" || len(Query(context.Background())) != 0 {
+		t.Error("expected no path parameters or query")
+	}
+}
@@ -0,0 +1,154 @@
+package fncmp
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// Router serves a HandleFn per path over a single connection.
+//
+// Following a link to one of its routes, or redirecting to one with
+// RedirectURL, pushes the URL to the browser history and renders the route's
+// FnComponent without reloading the page, so the connection, its event
+// listeners and cache are kept. Back and forward navigate the same way.
+// Links to other paths, and links with a target or download attribute, load
+// as usual.
+//
+// Routes are matched like the patterns of an http.ServeMux, and their
+// wildcards are available to HandleFns with PathParam. Mount the Router at
+// every path it serves so pages can also be loaded directly:
+//
+//	router := fncmp.NewRouter(layout)
+//	router.Handle("/{$}", home)
+//	router.Handle("/users/{id}", user)
+//	mux.Handle("/", router)
+type Router struct {
+	*Handler
+	mux *http.ServeMux
+}
+
+// route is a HandleFn registered with a Router's ServeMux
+type route HandleFn
+
+// ServeHTTP records the route matched by the ServeMux
+func (hf route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m, ok := w.(*routeMatch); ok {
+		m.hf, m.r = HandleFn(hf), r
+	}
+}
+
+// routeMatch is the ResponseWriter a Router's ServeMux serves to find the
+// route for a request. Responses written for other paths are discarded.
+type routeMatch struct {
+	hf     HandleFn
+	r      *http.Request
+	header http.Header
+}
+
+func (m *routeMatch) Header() http.Header {
+	if m.header == nil {
+		m.header = make(http.Header)
+	}
+	return m.header
+}
+func (m *routeMatch) Write(b []byte) (int, error) { return len(b), nil }
+func (m *routeMatch) WriteHeader(int)             {}
+
+// NewRouter returns a Router of the default App. See App.NewRouter.
+func NewRouter(h http.HandlerFunc, mw ...Middleware) *Router {
+	return defaultApp.NewRouter(h, mw...)
+}
+
+// NewRouter returns a Router that serves pages with h, which renders the
+// layout every route shares. Routes render into <main> unless their
+// FnComponent targets another element. Every route and its event callbacks
+// are wrapped by mw.
+func (a *App) NewRouter(h http.HandlerFunc, mw ...Middleware) *Router {
+	rt := &Router{mux: http.NewServeMux()}
+	handler := a.newHandler(rt.render, mw...)
+	handler.router = rt
+	// Register again now the handler has its router
+	a.handlers.Set(handler.id, *handler)
+	handler.listen()
+	rt.Handler = &Handler{app: a, page: h, handler: handler}
+	return rt
+}
+
+// Handle serves hf for paths matching pattern, wrapped by mw inside the
+// Middleware of the Router
+func (rt *Router) Handle(pattern string, hf HandleFn, mw ...Middleware) {
+	rt.mux.Handle(pattern, route(Chain(hf, mw...)))
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		if _, _, ok := rt.match(r); !ok {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	rt.Handler.ServeHTTP(w, r)
+}
+
+// match returns the HandleFn of the route for r and a copy of r carrying
+// the route's path parameters
+func (rt *Router) match(r *http.Request) (HandleFn, *http.Request, bool) {
+	// The ServeMux sets the path parameters on the request it serves
+	var m routeMatch
+	rt.mux.ServeHTTP(&m, r.Clone(r.Context()))
+	// ServeMux redirects, e.g. to add a trailing slash, are not routes
+	return m.hf, m.r, m.hf != nil
+}
+
+// render renders the route for the page of the connection in ctx
+func (rt *Router) render(ctx context.Context) FnComponent {
+	dd, ok := dispatchFromContext(ctx)
+	if !ok || dd.Conn == nil {
+		return NewFn(ctx, nil)
+	}
+	hf, page, ok := rt.match(dd.Conn.page())
+	if !ok {
+		return NewFn(ctx, nil)
+	}
+	dd.Conn.navigate(page)
+	return hf(dd.Conn.handlerContext(ctx, dd.HandlerID))
+}
+
+// navigate renders the route for target to c through h and, if push, tells
+// the client to push target to its history. It returns false if target is
+// not a route, or c is restricted, so the client must load it instead.
+func (rt *Router) navigate(h handler, c *conn, target string, push bool) bool {
+	// Loading the page authenticates the client again
+	if c.restricted {
+		return false
+	}
+	page, ok := withPath(c.page(), target)
+	if !ok {
+		return false
+	}
+	hf, page, ok := rt.match(page)
+	if !ok {
+		return false
+	}
+	c.navigate(page)
+	if push {
+		h.MarshalAndPublish(Dispatch{
+			ConnID:     c.ID,
+			HandlerID:  h.id,
+			Function:   redirect,
+			FnRedirect: FnRedirect{URL: page.URL.RequestURI(), Navigate: true},
+			conn:       c,
+		})
+	}
+	fn, ok := h.call(c.handlerContext(context.WithoutCancel(page.Context()), h.id), h.wrap(hf), "", "")
+	if !ok {
+		return true
+	}
+	fn.dispatch.conn = c
+	fn.dispatch.ConnID = c.ID
+	fn.dispatch.HandlerID = h.id
+	h.queueOut(fn)
+	return true
+}
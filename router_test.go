@@ -0,0 +1,203 @@
+package fncmp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouter(t *testing.T) {
+	a := NewApp(&Config{Silent: true})
+	router := a.NewRouter(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><head></head><body><main></main></body></html>"))
+	})
+	defer router.Close()
+	opened := make(chan string, 1)
+	a.OnDisconnect(func(ctx context.Context) {
+		opened <- ctx.Value(RequestKey).(*http.Request).URL.Path
+	})
+	page := func(ctx context.Context) FnComponent {
+		r := ctx.Value(RequestKey).(*http.Request)
+		return NewFn(ctx, HTML("<p>"+r.URL.Path+"</p>"))
+	}
+	router.Handle("/home", page)
+	router.Handle("/about", page)
+	router.Handle("/old", func(ctx context.Context) FnComponent {
+		return RedirectURL(ctx, "/about")
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// Pages are served for routes only
+	res, err := http.Get(server.URL + "/about")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", res.StatusCode)
+	}
+	if !strings.Contains(string(body), `<meta name="fncmp-router" content="`+router.ID()+`">`) {
+		t.Errorf("expected router meta, got %s", body)
+	}
+	if res, err = http.Get(server.URL + "/missing"); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", res.StatusCode)
+	}
+
+	ws := _test_dial(t, _test_url(a, server, "/home", t.Name()), nil)
+	if d := _test_read(t, ws, render); !strings.Contains(d.FnRender.HTML, "<p>/home</p>") {
+		t.Errorf("expected home, got %s", d.FnRender.HTML)
+	}
+
+	nav := func(url string, pop bool) {
+		t.Helper()
+		d := Dispatch{
+			Function:   navigate,
+			HandlerID:  router.ID(),
+			FnRedirect: FnRedirect{URL: url, Pop: pop},
+		}
+		if err := ws.WriteJSON(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectRender := func(path string) {
+		t.Helper()
+		if d := _test_read(t, ws, render); !strings.Contains(d.FnRender.HTML, "<p>"+path+"</p>") {
+			t.Errorf("expected %s, got %s", path, d.FnRender.HTML)
+		}
+	}
+
+	// Links push the route to the history and render it
+	nav("/about", false)
+	if d := _test_read(t, ws, redirect); d.FnRedirect.URL != "/about" || !d.FnRedirect.Navigate {
+		t.Errorf("expected navigation to /about, got %+v", d.FnRedirect)
+	}
+	expectRender("/about")
+	if _, ok := a.Connection(t.Name()); !ok {
+		t.Fatal("expected connection to be kept")
+	}
+
+	// Back and forward only render
+	nav("/home", true)
+	expectRender("/home")
+
+	// Redirects to routes navigate
+	nav("/old", false)
+	for {
+		d := _test_read(t, ws, redirect)
+		if !d.FnRedirect.Navigate {
+			t.Fatalf("expected navigation, got %+v", d.FnRedirect)
+		}
+		if d.FnRedirect.URL == "/about" {
+			break
+		}
+	}
+	expectRender("/about")
+
+	// Other paths are loaded
+	nav("/missing?q=1", false)
+	if d := _test_read(t, ws, redirect); d.FnRedirect.URL != "/missing?q=1" || d.FnRedirect.Navigate {
+		t.Errorf("expected redirect to /missing?q=1, got %+v", d.FnRedirect)
+	}
+
+	// Lifecycle functions get the request that opened the connection
+	ws.Close()
+	select {
+	case path := <-opened:
+		if path != "/home" {
+			t.Errorf("expected /home, got %s", path)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected disconnect")
+	}
+}
+
+func TestWithPath(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	cases := []struct {
+		path string
+		ok   bool
+	}{
+		{"/about?q=1", true},
+		{"about", false},
+		{"//example.com", false},
+		{"/\\example.com", false},
+		{"https://example.com/", false},
+	}
+	for _, c := range cases {
+		t.Run(c.path, func(t *testing.T) {
+			page, ok := withPath(r, c.path)
+			if ok != c.ok {
+				t.Fatalf("expected %v, got %v", c.ok, ok)
+			}
+			if ok && page.URL.RequestURI() != c.path {
+				t.Errorf("expected %s, got %s", c.path, page.URL.RequestURI())
+			}
+		})
+	}
+}
+
+func TestRouterRestricted(t *testing.T) {
+	a := NewApp(&Config{
+		Silent: true,
+		Authenticator: AuthenticatorFunc(func(ctx context.Context, token string) (any, error) {
+			return nil, ErrUnauthorized
+		}),
+		UnauthenticatedHandler: func(ctx context.Context) FnComponent {
+			return NewFn(ctx, HTML("<p>sign in</p>")).WithEvents(func(ctx context.Context) FnComponent {
+				return RedirectURL(ctx, "/admin")
+			}, OnClick)
+		},
+	})
+	router := a.NewRouter(func(w http.ResponseWriter, r *http.Request) {})
+	defer router.Close()
+	router.Handle("/admin", func(ctx context.Context) FnComponent {
+		return NewFn(ctx, HTML("<p>admin</p>"))
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ws := _test_dial(t, _test_url(a, server, "/admin?fncmp_auth=wrong", t.Name()), nil)
+	d := _test_read(t, ws, render)
+	if !strings.Contains(d.FnRender.HTML, "<p>sign in</p>") {
+		t.Fatalf("expected sign in, got %s", d.FnRender.HTML)
+	}
+
+	// Navigation is refused and redirects load the page
+	if err := ws.WriteJSON(Dispatch{
+		Function:   navigate,
+		HandlerID:  router.ID(),
+		FnRedirect: FnRedirect{URL: "/admin"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WriteJSON(Dispatch{
+		Function:  event,
+		HandlerID: router.ID(),
+		FnEvent:   EventListener{ID: d.FnRender.EventListeners[0].ID},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if d := _test_read(t, ws, redirect); d.FnRedirect.URL != "/admin" || d.FnRedirect.Navigate {
+		t.Errorf("expected redirect to load /admin, got %+v", d.FnRedirect)
+	}
+	ws.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	for {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			break
+		}
+		if strings.Contains(string(msg), "admin</p>") {
+			t.Fatalf("expected restricted connection not to render a route, got %s", msg)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package fncmp
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// task is a function scheduled to run at a time
+type task struct {
+	at    time.Time
+	fn    func()
+	index int // Position in the heap, -1 once run or cancelled
+	s     *scheduler
+}
+
+// Cancel stops the task from running if it has not run yet
+func (t *task) Cancel() {
+	if t == nil || t.s == nil {
+		return
+	}
+	t.s.cancel(t)
+}
+
+// tasks is a min-heap of tasks ordered by time
+type tasks []*task
+
+func (h tasks) Len() int           { return len(h) }
+func (h tasks) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h tasks) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *tasks) Push(x any) {
+	t := x.(*task)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+func (h *tasks) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	t.index = -1
+	*h = old[:len(old)-1]
+	return t
+}
+
+// scheduler runs every timer of an App from a single goroutine, which only
+// runs while timers are pending. Each timer runs in its own goroutine so a
+// slow one does not delay the others.
+type scheduler struct {
+	mu      sync.Mutex
+	app     *App
+	tasks   tasks
+	running bool
+	stopped bool
+	// wake is signalled when a task is scheduled before the next one
+	wake chan struct{}
+}
+
+// After schedules fn to run after d
+func (s *scheduler) After(d time.Duration, fn func()) *task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := &task{at: time.Now().Add(d), fn: fn, index: -1}
+	if s.stopped {
+		return t
+	}
+	t.s = s
+	heap.Push(&s.tasks, t)
+	if !s.running {
+		s.running = true
+		s.app.goroutines.Go(goScheduler, s.loop)
+	} else if t.index == 0 {
+		signal(s.wake)
+	}
+	return t
+}
+
+func (s *scheduler) cancel(t *task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.index >= 0 {
+		heap.Remove(&s.tasks, t.index)
+	}
+}
+
+// stop cancels every pending task and ignores tasks scheduled later
+func (s *scheduler) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	for _, t := range s.tasks {
+		t.index = -1
+	}
+	s.tasks = nil
+	signal(s.wake)
+}
+
+func (s *scheduler) loop() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		if len(s.tasks) == 0 {
+			s.running = false
+			s.mu.Unlock()
+			return
+		}
+		next := s.tasks[0]
+		wait := time.Until(next.at)
+		if wait <= 0 {
+			heap.Pop(&s.tasks)
+			s.mu.Unlock()
+			s.app.goroutines.Go(goScheduler, next.fn)
+			continue
+		}
+		s.mu.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package fncmp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	a := NewApp(&Config{Silent: true})
+	ran := make(chan int, 3)
+	a.scheduler.After(30*time.Millisecond, func() { ran <- 3 })
+	cancelled := a.scheduler.After(20*time.Millisecond, func() { ran <- 2 })
+	a.scheduler.After(10*time.Millisecond, func() { ran <- 1 })
+	cancelled.Cancel()
+
+	for _, exp := range []int{1, 3} {
+		select {
+		case got := <-ran:
+			if got != exp {
+				t.Errorf("expected task %d, got %d", exp, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected task %d to run", exp)
+		}
+	}
+	select {
+	case got := <-ran:
+		t.Errorf("expected cancelled task not to run, got %d", got)
+	case <-time.After(20 * time.Millisecond):
+	}
+	_test_goroutines(t, a, goScheduler, 0)
+
+	// Stopped schedulers run nothing
+	a.scheduler.stop()
+	a.scheduler.After(0, func() { ran <- 0 })
+	select {
+	case <-ran:
+		t.Error("expected stopped scheduler not to run tasks")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// _test_goroutines waits for the App to have exp goroutines in subsystem
+func _test_goroutines(t *testing.T, a *App, subsystem string, exp int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for a.Goroutines()[subsystem] != exp {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d %s goroutines, got %v", exp, subsystem, a.Goroutines())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
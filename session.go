@@ -0,0 +1,70 @@
+package fncmp
+
+import (
+	"context"
+	"fmt"
+)
+
+// A session is every tab of a browser sharing one connection token. Each tab
+// opens its own connection with an ID made from the session ID and a tab ID
+// chosen by the client, and the tabs share a cache store.
+
+// maxTabIDLength limits the tab ID sent by the client
+const maxTabIDLength = 64
+
+// connID returns the connection ID for tab within session. Clients that do
+// not send a tab ID connect with the session ID.
+func connID(session, tab string) (string, error) {
+	if tab == "" {
+		return session, nil
+	}
+	if len(tab) > maxTabIDLength {
+		return "", fmt.Errorf("%w: tab ID too long", ErrInvalidToken)
+	}
+	for _, r := range tab {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return "", fmt.Errorf("%w: invalid tab ID", ErrInvalidToken)
+		}
+	}
+	return session + "." + tab, nil
+}
+
+func sessionTopic(session string) string {
+	return "session:" + session
+}
+
+// subscribeSession subscribes c to the topic of its session
+func subscribeSession(c *conn) {
+	c.app.topics.Add(sessionTopic(c.Session), c.ID)
+	c.app.subscribeTopic(sessionTopic(c.Session))
+}
+
+// sessionActive reports whether any connection of session is active
+func (a *App) sessionActive(session string) bool {
+	for _, c := range a.connPool.All() {
+		if c.Session == session {
+			return true
+		}
+	}
+	return false
+}
+
+// SessionID returns the session ID of the connection in ctx
+func SessionID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(SessionIDKey).(string)
+	return id, ok
+}
+
+// PublishSession calls h with the context of every connected tab in the
+// session of ctx, including the current one, and dispatches the returned
+// FnComponent to that tab. Use FnComponent.Dispatch to reach only the
+// current tab.
+//
+// PublishSession returns the number of tabs dispatched to.
+func PublishSession(ctx context.Context, h HandleFn) (int, error) {
+	dd, ok := dispatchFromContext(ctx)
+	if !ok {
+		return 0, ErrCtxMissingDispatch
+	}
+	return dd.app().Publish(sessionTopic(dd.SessionID), h), nil
+}
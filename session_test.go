@@ -0,0 +1,56 @@
+package fncmp
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSessionTabs(t *testing.T) {
+	server := _test_server(func(ctx context.Context) FnComponent {
+		return NewFn(ctx, HTML("<p>tab</p>"))
+	})
+	defer server.Close()
+
+	tabs := map[string]*websocket.Conn{}
+	for _, tab := range []string{"a", "b"} {
+		ws, _, err := websocket.DefaultDialer.Dial(_test_url(defaultApp, server, "/", t.Name())+"&fncmp_tab="+tab, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+		_test_read(t, ws, render)
+		tabs[tab] = ws
+	}
+
+	a, ok := defaultApp.connPool.Get(t.Name() + ".a")
+	if !ok {
+		t.Fatal("expected tab a to stay connected")
+	}
+	b, ok := defaultApp.connPool.Get(t.Name() + ".b")
+	if !ok {
+		t.Fatal("expected tab b to be connected")
+	}
+
+	// Tabs share the session's cache
+	if _, err := NewCache(a.context(), "shared", 1); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := UseCache[int](b.context(), "shared"); err != nil || c.Value() != 1 {
+		t.Errorf("expected shared cache, got %v", err)
+	}
+
+	n, err := PublishSession(a.context(), func(ctx context.Context) FnComponent {
+		return NewFn(ctx, HTML("<p>all</p>"))
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 tabs, got %d, %v", n, err)
+	}
+	for tab, ws := range tabs {
+		if d := _test_read(t, ws, render); !strings.Contains(d.FnRender.HTML, "<p>all</p>") {
+			t.Errorf("expected tab %s to receive session dispatch, got %s", tab, d.FnRender.HTML)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package fncmp
+
+import (
+	"context"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Shutdown gracefully stops the default App. See App.Shutdown.
+func Shutdown(ctx context.Context) error {
+	return defaultApp.Shutdown(ctx)
+}
+
+// Shutdown gracefully stops the App.
+//
+// It stops accepting new connections and events, waits for queued
+// dispatches and in-flight event handlers to finish, then sends a close
+// frame to every connected client and stops all handlers and timers. If ctx
+// expires first, the clients are disconnected anyway, which cancels the
+// contexts of the events still running, and the context's error is
+// returned.
+//
+// Shutdown is intended to be called alongside http.Server.Shutdown.
+func (a *App) Shutdown(ctx context.Context) error {
+	a.shuttingDown.Store(true)
+
+	var err error
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for a.inflight.Load() > 0 && err == nil {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	for _, c := range a.connPool.All() {
+		c.closeWith(websocket.CloseGoingAway, ErrShuttingDown.Error())
+	}
+	a.handlers.closeAll()
+	a.scheduler.stop()
+	return err
+}
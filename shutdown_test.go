@@ -0,0 +1,188 @@
+package fncmp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestShutdownDrainsEvents(t *testing.T) {
+	a := NewApp(&Config{Silent: true})
+	started, release := make(chan struct{}), make(chan struct{})
+	cancelled := make(chan error, 1)
+	ws, click := _test_click_server(t, a, func(ctx context.Context) FnComponent {
+		close(started)
+		select {
+		case <-release:
+		case <-ctx.Done():
+			cancelled <- context.Cause(ctx)
+		}
+		return NewFn(ctx, HTML("<p>done</p>"))
+	})
+	click(nil)
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- a.Shutdown(context.Background()) }()
+
+	// In-flight handlers finish before clients are disconnected
+	select {
+	case err := <-cancelled:
+		t.Fatalf("expected handler to drain, got cancelled with %v", err)
+	case err := <-shutdown:
+		t.Fatalf("expected Shutdown to wait for the handler, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, _, err := ws.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				t.Errorf("expected going away, got %v", err)
+			}
+			break
+		}
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	a := NewApp(&Config{Silent: true})
+	started, release := make(chan struct{}), make(chan struct{})
+	cancelled := make(chan error, 1)
+	defer close(release)
+	ws, click := _test_click_server(t, a, func(ctx context.Context) FnComponent {
+		close(started)
+		select {
+		case <-release:
+		case <-ctx.Done():
+			cancelled <- context.Cause(ctx)
+		}
+		return NewFn(ctx, HTML("<p>done</p>"))
+	})
+	click(nil)
+	<-started
+
+	// Handlers outliving ctx are cancelled and the clients disconnected
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := a.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("expected handler to be cancelled")
+	}
+	for {
+		_, _, err := ws.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				t.Errorf("expected going away, got %v", err)
+			}
+			break
+		}
+	}
+}
+
+func TestShutdownRejectsConnections(t *testing.T) {
+	a := NewApp(&Config{Silent: true})
+	server := httptest.NewServer(a.MiddleWareFn(
+		func(w http.ResponseWriter, r *http.Request) {},
+		func(ctx context.Context) FnComponent { return NewFn(ctx, HTML("<p>page</p>")) },
+	))
+	defer server.Close()
+	if err := a.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, res, err := websocket.DefaultDialer.Dial(_test_url(a, server, "/", t.Name()), nil)
+	if err == nil {
+		t.Fatal("expected upgrade to fail")
+	}
+	if res == nil || res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected %d, got %v", http.StatusServiceUnavailable, res)
+	}
+}
+
+func TestShutdownPendingAuth(t *testing.T) {
+	a := NewApp(&Config{
+		Silent: true,
+		Authenticator: AuthenticatorFunc(func(ctx context.Context, token string) (any, error) {
+			return token, nil
+		}),
+	})
+	server := httptest.NewServer(a.MiddleWareFn(
+		func(w http.ResponseWriter, r *http.Request) {},
+		func(ctx context.Context) FnComponent { return NewFn(ctx, HTML("<p>page</p>")) },
+	))
+	defer server.Close()
+
+	// The client is upgraded and authenticates after Shutdown has started
+	ws := _test_dial(t, _test_url(a, server, "/", t.Name()), nil)
+	if err := a.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WriteJSON(Dispatch{Function: auth, FnAuth: FnAuth{Token: "secret"}}); err != nil {
+		t.Fatal(err)
+	}
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				t.Errorf("expected going away, got %v", err)
+			}
+			break
+		}
+	}
+	if _, ok := a.Connection(t.Name()); ok {
+		t.Error("expected connection to be refused")
+	}
+}
+
+func TestShutdownAfterClose(t *testing.T) {
+	a := NewApp(&Config{Silent: true})
+	started, release := make(chan struct{}), make(chan struct{})
+	h := a.NewHandler(func(w http.ResponseWriter, r *http.Request) {}, func(ctx context.Context) FnComponent {
+		return NewFn(ctx, HTML("<p>page</p>")).WithEvents(func(ctx context.Context) FnComponent {
+			close(started)
+			<-release
+			return NewFn(ctx, HTML("<p>done</p>"))
+		}, OnClick)
+	})
+	server := httptest.NewServer(h)
+	defer server.Close()
+	ws := _test_dial(t, _test_url(a, server, "/", t.Name()), nil)
+	d := _test_read(t, ws, render)
+	if err := ws.WriteJSON(Dispatch{
+		Function:  event,
+		HandlerID: h.ID(),
+		FnEvent:   EventListener{ID: d.FnRender.EventListeners[0].ID},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	// Dispatches queued after Close, e.g. by the reader or by events still
+	// running, are not left counted as in flight
+	h.Close()
+	close(release)
+	for a.Goroutines()[goHandler] > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 20; i++ {
+		h.handler.queueIn(Dispatch{Function: event})
+		h.handler.queueOut(NewFn(context.Background(), HTML("<p>late</p>")))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := a.Shutdown(ctx); err != nil {
+		t.Errorf("expected in-flight events to drain, got %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+package fncmp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxSocketHandlers limits the handlers a client may mount on one connection
+const maxSocketHandlers = 32
+
+// SocketHandler returns the socket endpoint of the default App. See
+// App.SocketHandler.
+func SocketHandler() http.HandlerFunc {
+	return defaultApp.SocketHandler()
+}
+
+// SocketHandler returns a handler for a single WebSocket endpoint serving
+// every handler created with the App's MiddleWareFn. Mount it at
+// Config.SocketPath:
+//
+//	fncmp.SetConfig(&fncmp.Config{SocketPath: "/fncmp"})
+//	mux.Handle("/fncmp", fncmp.SocketHandler())
+//
+// Pages then connect to Config.SocketPath instead of their own path, and the
+// handlers of every MiddleWareFn that rendered into the page share the
+// connection. Dispatches are routed to handlers by their handler ID.
+func (a *App) SocketHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.shuttingDown.Load() {
+			http.Error(w, ErrShuttingDown.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		ids := r.URL.Query()["fncmp_handler"]
+		if len(ids) == 0 || len(ids) > maxSocketHandlers {
+			http.Error(w, ErrHandlerNotFound.Error(), http.StatusBadRequest)
+			return
+		}
+		hs := make([]handler, 0, len(ids))
+		seen := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			h, ok := a.handlers.Get(id)
+			if !ok {
+				a.config.Logger.Warn(ErrConnectionFailed, "reason", ErrHandlerNotFound, "handler_id", id)
+				http.Error(w, ErrHandlerNotFound.Error(), http.StatusNotFound)
+				return
+			}
+			if !seen[id] {
+				seen[id] = true
+				hs = append(hs, h)
+			}
+		}
+		a.serveConn(w, r, hs...)
+	}
+}
+
+// serveConn upgrades r to a connection carrying hs and serves it until the
+// client disconnects. The first handler is the connection's primary handler.
+func (a *App) serveConn(w http.ResponseWriter, r *http.Request, hs ...handler) {
+	config := a.config
+	// Clients of other versions, which may not send a token, are told to
+	// reload before the token is verified
+	version, err := clientVersion(r)
+	if err != nil {
+		config.Logger.Warn(ErrConnectionFailed, "reason", err)
+		a.rejectVersion(w, r, version)
+		return
+	}
+	var session string
+	if version == legacyProtocolVersion {
+		session = a.legacySession(r)
+	} else if session, err = a.verifyConnToken(connToken(r)); err != nil {
+		config.Logger.Warn(ErrConnectionFailed, "reason", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	id, err := connID(session, r.URL.Query().Get("fncmp_tab"))
+	if err != nil {
+		config.Logger.Warn(ErrConnectionFailed, "reason", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ids := make([]string, len(hs))
+	for i, h := range hs {
+		ids[i] = h.id
+	}
+	newConnection, err := a.newConn(w, r, ids, session, id, version)
+	if err != nil {
+		config.Logger.Error(ErrConnectionFailed, "reason", err)
+		if errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrShuttingDown) {
+			// newConn has already responded
+			return
+		}
+		if errors.Is(err, ErrOriginNotAllowed) {
+			http.Error(w, ErrOriginNotAllowed.Error(), http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(ErrConnectionFailed))
+		return
+	}
+	primary := hs[0]
+
+	// A client reconnecting with the last sequence number it received
+	// resumes its session instead of rendering from scratch
+	lastSeq, err := strconv.ParseUint(r.URL.Query().Get("fncmp_seq"), 10, 64)
+	// Unauthenticated clients never resume a session
+	resuming := err == nil && !newConnection.restricted
+	if ob, ok := a.outboxes.Get(id); resuming && ok {
+		a.goroutines.Go(goReplay, func() { ob.replay(newConnection, lastSeq) })
+	} else if resuming {
+		// Session expired, client must reload the page
+		d := newConnection.reloadDispatch(errCodeSessionExpired, "session expired")
+		if err := newConnection.send(d); err != nil {
+			config.Logger.Error("failed to send reload", "conn_id", id, "error", err)
+		}
+	} else {
+		// Fresh page, discard state left by any previous page
+		a.evtListeners.Delete(newConnection)
+		a.outboxes.Reset(id)
+		a.deleteConn(id)
+
+		// Send initial fn of each handler to client
+		for _, h := range hs {
+			render := h.wrap(h.render)
+			if newConnection.restricted {
+				// Only the primary handler renders, in its restricted form
+				if h.id != primary.id {
+					continue
+				}
+				// Middleware is not applied to the restricted form
+				render = config.UnauthenticatedHandler
+			}
+			fn, ok := h.call(newConnection.handlerContext(r.Context(), h.id), render, "", "")
+			if !ok {
+				continue
+			}
+			fn.dispatch.conn = newConnection
+			fn.dispatch.ConnID = id
+			fn.dispatch.HandlerID = h.id
+			h.queueOut(fn)
+		}
+	}
+
+	subscribeSession(newConnection)
+
+	pinger := newDispatch(id)
+	pinger.Function = ping
+	pinger.FnPing.Server = true
+	pinger.conn = newConnection
+	pinger.ConnID = id
+	pinger.HandlerID = primary.id
+
+	// Send ping to client
+	a.goroutines.Go(goHeartbeat, func() { newConnection.heartbeat(primary, *pinger) })
+
+	newConnection.listen()
+}
+
+// pageRequest returns r with the URL of the page the client connected from,
+// sent as fncmp_path, so HandleFns see the page's path and query rather than
+// those of the WebSocket handshake. Clients that do not send fncmp_path get
+// the handshake's URL without the parameters of the library.
+func pageRequest(r *http.Request) *http.Request {
+	if page, ok := withPath(r, r.URL.Query().Get("fncmp_path")); ok {
+		return page
+	}
+	page := r.Clone(r.Context())
+	page.URL.RawQuery = withoutParams(r.URL.RawQuery)
+	page.RequestURI = page.URL.RequestURI()
+	return page
+}
+
+// withPath returns a copy of r for path, with an optional query. Only paths
+// on this host are accepted.
+func withPath(r *http.Request, path string) (*http.Request, bool) {
+	// Browsers treat "/\" like "//"
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return nil, false
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, false
+	}
+	page := r.Clone(r.Context())
+	page.URL.Path, page.URL.RawPath, page.URL.RawQuery = u.Path, u.RawPath, withoutParams(u.RawQuery)
+	page.RequestURI = page.URL.RequestURI()
+	return page, true
+}
+
+// withoutParams returns query without the fncmp_ parameters the client sends
+// with the handshake, which carry tokens HandleFns must not see
+func withoutParams(query string) string {
+	if unescaped, err := url.QueryUnescape(query); err == nil && !strings.Contains(unescaped, "fncmp_") {
+		return query
+	}
+	values, _ := url.ParseQuery(query)
+	for key := range values {
+		if strings.HasPrefix(key, "fncmp_") {
+			delete(values, key)
+		}
+	}
+	return values.Encode()
+}
+
+// injectMeta adds the meta tags telling the client which handler rendered
+// the page, whether it is a Router and, if set, the path of SocketHandler.
+// They are placed before </head> or, if the page has none, after the
+// opening <head>, <html> or doctype. See metaOffset.
+func (a *App) injectMeta(page []byte, handlerID string, router bool) []byte {
+	var meta bytes.Buffer
+	fmt.Fprintf(&meta, `<meta name="fncmp-handler" content="%s">`, html.EscapeString(handlerID))
+	if router {
+		fmt.Fprintf(&meta, `<meta name="fncmp-router" content="%s">`, html.EscapeString(handlerID))
+	}
+	if a.config.SocketPath != "" {
+		fmt.Fprintf(&meta, `<meta name="fncmp-socket" content="%s">`, html.EscapeString(a.config.SocketPath))
+	}
+	i := metaOffset(page)
+	out := make([]byte, 0, len(page)+meta.Len())
+	out = append(out, page[:i]...)
+	out = append(out, meta.Bytes()...)
+	return append(out, page[i:]...)
+}
+
+// metaOffset returns where injectMeta inserts into page. Tags before the
+// doctype would put the page into quirks mode, so only fragments get them
+// first.
+func metaOffset(page []byte) int {
+	lower := bytes.ToLower(page)
+	if i := bytes.Index(lower, []byte("</head>")); i >= 0 {
+		return i
+	}
+	for _, tag := range []string{"<head", "<html", "<!doctype"} {
+		i := bytes.Index(lower, []byte(tag))
+		if i < 0 {
+			continue
+		}
+		// The tag name must end there, e.g. not <header
+		if rest := lower[i+len(tag):]; len(rest) > 0 && rest[0] != '>' && !isSpace(rest[0]) {
+			continue
+		}
+		if end := bytes.IndexByte(lower[i:], '>'); end >= 0 {
+			return i + end + 1
+		}
+	}
+	return 0
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f'
+}
@@ -0,0 +1,91 @@
+package fncmp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSocketHandler(t *testing.T) {
+	path := defaultApp.config.SocketPath
+	defaultApp.config.SocketPath = "/fncmp"
+	defer func() { defaultApp.config.SocketPath = path }()
+
+	page := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><head></head><body></body></html>"))
+	}
+	pageFn := func(name string) HandleFn {
+		return func(ctx context.Context) FnComponent {
+			r := ctx.Value(RequestKey).(*http.Request)
+			return NewFn(ctx, HTML("<p>"+name+" "+r.URL.Path+"</p>"))
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/a/", MiddleWareFn(page, pageFn("a")))
+	mux.Handle("/b/", MiddleWareFn(page, pageFn("b")))
+	mux.Handle("/fncmp", SocketHandler())
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// Each page names its handler and the socket path
+	meta := regexp.MustCompile(`<meta name="fncmp-handler" content="([^"]+)"><meta name="fncmp-socket" content="/fncmp"></head>`)
+	var ids []string
+	for _, p := range []string{"/a/", "/b/"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", p, nil))
+		m := meta.FindStringSubmatch(w.Body.String())
+		if m == nil {
+			t.Fatalf("expected meta tags, got %s", w.Body.String())
+		}
+		ids = append(ids, m[1])
+	}
+
+	u := _test_url(defaultApp, server, "/fncmp?fncmp_handler="+ids[0]+"&fncmp_handler="+ids[1]+"&fncmp_path=%2Fa%2Fpage", t.Name())
+	ws := _test_dial(t, u, nil)
+
+	got := map[string]string{}
+	for len(got) < 2 {
+		d := _test_read(t, ws, render)
+		got[d.HandlerID] = d.FnRender.HTML
+	}
+	for i, name := range []string{"a", "b"} {
+		if !strings.Contains(got[ids[i]], "<p>"+name+" /a/page</p>") {
+			t.Errorf("expected handler %s to render with page path, got %s", name, got[ids[i]])
+		}
+	}
+	if info, ok := Connection(t.Name()); !ok || len(info.HandlerIDs) != 2 {
+		t.Errorf("expected connection to carry 2 handlers, got %+v", info)
+	}
+
+	// Unknown handlers are not upgraded
+	_, resp, err := websocket.DefaultDialer.Dial(u+"&fncmp_handler=unknown", nil)
+	if err == nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+func TestInjectMeta(t *testing.T) {
+	cases := []struct {
+		name string
+		page string
+		exp  string
+	}{
+		{"head", "<html><HEAD></HEAD></html>", `<html><HEAD><meta name="fncmp-handler" content="id"></HEAD></html>`},
+		{"fragment", "<p>x</p>", `<meta name="fncmp-handler" content="id"><p>x</p>`},
+		{"open head", "<!DOCTYPE html><html><head lang=en><title>x</title><body></body>", `<!DOCTYPE html><html><head lang=en><meta name="fncmp-handler" content="id"><title>x</title><body></body>`},
+		{"html", "<!doctype html>\n<html>\n<header>x</header>", "<!doctype html>\n<html><meta name=\"fncmp-handler\" content=\"id\">\n<header>x</header>"},
+		{"doctype", "<!DOCTYPE html><p>x</p>", `<!DOCTYPE html><meta name="fncmp-handler" content="id"><p>x</p>`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := string(defaultApp.injectMeta([]byte(c.page), "id", false)); got != c.exp {
+				t.Errorf("expected %s, got %s", c.exp, got)
+			}
+		})
+	}
+}
@@ -1,35 +1,93 @@
 import { Dispatch, DispatchFunctions, FnEventListener, Fun } from "./fncmp_types";
+import { encode } from "./codec";
 
 export class API {
     private ws: WebSocket | null = null;
+    // Dispatches sent while the socket was not open
+    private pending: Dispatch[] = [];
 
     constructor(ws: WebSocket) {
+        this.SetSocket(ws);
+    }
+
+    // SetSocket swaps the underlying socket after a reconnect, keeping
+    // existing event listeners bound to this API
+    public SetSocket(ws: WebSocket) {
         this.ws = ws;
+        ws.addEventListener("open", () => {
+            const pending = this.pending;
+            this.pending = [];
+            pending.forEach((d) => this.Dispatch(d));
+        });
     }
 
     public Process(d: Dispatch) {
+        const ack = this.ackFor(d);
         switch (d.function) {
             case Fun.REDIRECT:
+                this.Dispatch(ack);
+                if (d.redirect.navigate) {
+                    // A Router renders the route over the connection
+                    history.pushState(null, "", d.redirect.url);
+                    return;
+                }
                 window.location.href = d.redirect.url;
-                break;
+                return;
+            case Fun.ERROR:
+                // Errors from the server, e.g. an incompatible client bundle
+                if (d.error.reload) {
+                    // Firefox bypasses the cache when forced
+                    (window.location.reload as (force?: boolean) => void)(true);
+                    return;
+                }
+                console.error("fncmp: " + d.error.message);
+                return;
             default:
                 if(!this.funs[d.function]) {
                     this.Error(d, "function not found: " + d.function);
-                    break;
+                    return;
                 }
                 const result = this.funs[d.function](d);
+                // Failed dispatches are reported as errors instead
+                if (d.function === Fun.ERROR) return;
+                this.Dispatch(ack);
                 if (!result) break;
                 this.Dispatch(result);
                 break;
         }
     }
 
+    // Navigate asks the Router with handlerID to render url. pop is set
+    // when the browser has already moved to url, e.g. on back or forward.
+    public Navigate(handlerID: string, url: string, pop: boolean) {
+        this.Dispatch({
+            function: Fun.NAVIGATE,
+            handler_id: handlerID,
+            redirect: { url: url, pop: pop },
+        } as Dispatch);
+    }
+
+    // ackFor returns the acknowledgement for a sequenced dispatch
+    private ackFor(d: Dispatch): Dispatch | void {
+        if (!d.seq) return;
+        return {
+            function: Fun.ACK,
+            seq: d.seq,
+            conn_id: d.conn_id,
+            handler_id: d.handler_id,
+        } as Dispatch;
+    }
+
     private Dispatch = (data: Dispatch | void) => {
         if (!data) return;
         if (!this.ws) {
             throw new Error("ws: not connected to server...");
         }
-        this.ws.send(JSON.stringify(data));
+        if (this.ws.readyState !== WebSocket.OPEN) {
+            this.pending.push(data);
+            return;
+        }
+        this.ws.send(encode(this.ws, data));
     };
 
     private funs: DispatchFunctions = {
@@ -0,0 +1,161 @@
+import { Dispatch } from "./fncmp_types";
+
+// Codecs offered to the server, in order of preference
+const PROTOCOLS = ["fncmp.msgpack", "fncmp.json"];
+const MSGPACK = "fncmp.msgpack";
+
+// encode encodes d with the codec negotiated for ws
+function encode(ws: WebSocket, d: Dispatch): string | Uint8Array {
+    if (ws.protocol === MSGPACK) return pack(d);
+    return JSON.stringify(d);
+}
+
+// decode decodes a message from the server, which is binary MessagePack or
+// JSON text
+function decode(data: string | ArrayBuffer): Dispatch {
+    if (typeof data === "string") return withDefaults(JSON.parse(data));
+    return withDefaults(unpack(new Uint8Array(data)));
+}
+
+// withDefaults fills in the zero values the server leaves out of MessagePack
+// frames, which only carry the fields that are set
+function withDefaults(d: any): Dispatch {
+    const fill = (v: any, zero: any) => {
+        const out = v && typeof v === "object" ? v : {};
+        Object.keys(zero).forEach((k) => {
+            if (out[k] === undefined || out[k] === null) out[k] = zero[k];
+        });
+        return out;
+    };
+    d = fill(d, { function: "", id: "", key: "", conn_id: "", handler_id: "", action: "", label: "" });
+    d.auth = fill(d.auth, { key: "", token: "" });
+    d.event = fill(d.event, { id: "", target_id: "", on: "", data: null });
+    d.ping = fill(d.ping, { server: false, client: false, sent: 0 });
+    d.render = fill(d.render, {
+        target_id: "", tag: "", inner: false, outer: false, append: false,
+        prepend: false, remove: false, html: "", event_listeners: [],
+    });
+    d.render.event_listeners = d.render.event_listeners.map((l: any) =>
+        fill(l, { id: "", target_id: "", on: "", data: null }));
+    d.class = fill(d.class, { target_id: "", remove: false, names: [] });
+    d.redirect = fill(d.redirect, { url: "" });
+    d.custom = fill(d.custom, { function: "", data: null, result: null });
+    d.error = fill(d.error, { message: "" });
+    return d as Dispatch;
+}
+
+const utf8Encoder = new TextEncoder();
+const utf8Decoder = new TextDecoder();
+
+function pack(value: any): Uint8Array {
+    const out: number[] = [];
+    const u32 = (n: number) => out.push((n >>> 24) & 0xff, (n >>> 16) & 0xff, (n >>> 8) & 0xff, n & 0xff);
+    const header = (n: number, fix: number, wide: number) => {
+        if (n < 16) out.push(fix | n);
+        else if (n <= 0xffff) out.push(wide, n >> 8, n & 0xff);
+        else { out.push(wide + 1); u32(n); }
+    };
+    const write = (v: any) => {
+        if (v === null || v === undefined) {
+            out.push(0xc0);
+        } else if (typeof v === "boolean") {
+            out.push(v ? 0xc3 : 0xc2);
+        } else if (typeof v === "number") {
+            if (Number.isInteger(v) && v >= 0 && v <= 0x7f) {
+                out.push(v);
+            } else if (Number.isInteger(v) && v < 0 && v >= -32) {
+                out.push(v & 0xff);
+            } else if (Number.isInteger(v) && v >= -0x80000000 && v <= 0x7fffffff) {
+                out.push(0xd2);
+                u32(v);
+            } else {
+                const buf = new DataView(new ArrayBuffer(8));
+                buf.setFloat64(0, v);
+                out.push(0xcb, ...new Uint8Array(buf.buffer));
+            }
+        } else if (typeof v === "string") {
+            const b = utf8Encoder.encode(v);
+            if (b.length < 32) out.push(0xa0 | b.length);
+            else if (b.length <= 0xff) out.push(0xd9, b.length);
+            else if (b.length <= 0xffff) out.push(0xda, b.length >> 8, b.length & 0xff);
+            else { out.push(0xdb); u32(b.length); }
+            b.forEach((c) => out.push(c));
+        } else if (Array.isArray(v)) {
+            header(v.length, 0x90, 0xdc);
+            v.forEach(write);
+        } else {
+            const keys = Object.keys(v).filter((k) => v[k] !== undefined);
+            header(keys.length, 0x80, 0xde);
+            keys.forEach((k) => {
+                write(k);
+                write(v[k]);
+            });
+        }
+    };
+    write(value);
+    return new Uint8Array(out);
+}
+
+function unpack(data: Uint8Array): any {
+    const view = new DataView(data.buffer, data.byteOffset, data.byteLength);
+    let pos = 0;
+    const uint = (n: number): number => {
+        let v = 0;
+        for (let i = 0; i < n; i++) v = v * 256 + data[pos++];
+        return v;
+    };
+    const str = (n: number): string => {
+        const s = utf8Decoder.decode(data.subarray(pos, pos + n));
+        pos += n;
+        return s;
+    };
+    const array = (n: number): any[] => {
+        const a = [];
+        for (let i = 0; i < n; i++) a.push(read());
+        return a;
+    };
+    const object = (n: number): any => {
+        const o: any = {};
+        for (let i = 0; i < n; i++) {
+            const k = read();
+            o[k] = read();
+        }
+        return o;
+    };
+    const read = (): any => {
+        if (pos >= data.length) throw new Error("msgpack: unexpected end of data");
+        const c = data[pos++];
+        if (c <= 0x7f) return c;
+        if (c >= 0xe0) return c - 0x100;
+        if ((c & 0xe0) === 0xa0) return str(c & 0x1f);
+        if ((c & 0xf0) === 0x90) return array(c & 0x0f);
+        if ((c & 0xf0) === 0x80) return object(c & 0x0f);
+        let v: number;
+        switch (c) {
+            case 0xc0: return null;
+            case 0xc2: return false;
+            case 0xc3: return true;
+            case 0xcc: return uint(1);
+            case 0xcd: return uint(2);
+            case 0xce: return uint(4);
+            case 0xcf: return uint(8);
+            case 0xd0: v = view.getInt8(pos); pos += 1; return v;
+            case 0xd1: v = view.getInt16(pos); pos += 2; return v;
+            case 0xd2: v = view.getInt32(pos); pos += 4; return v;
+            case 0xd3: v = Number(view.getBigInt64(pos)); pos += 8; return v;
+            case 0xca: v = view.getFloat32(pos); pos += 4; return v;
+            case 0xcb: v = view.getFloat64(pos); pos += 8; return v;
+            case 0xd9: case 0xc4: return str(uint(1));
+            case 0xda: case 0xc5: return str(uint(2));
+            case 0xdb: case 0xc6: return str(uint(4));
+            case 0xdc: return array(uint(2));
+            case 0xdd: return array(uint(4));
+            case 0xde: return object(uint(2));
+            case 0xdf: return object(uint(4));
+        }
+        throw new Error("msgpack: unsupported format 0x" + c.toString(16));
+    };
+    return read();
+}
+
+export { PROTOCOLS, encode, decode, pack, unpack, withDefaults };
@@ -1,16 +1,20 @@
+// PROTOCOL_VERSION must match fncmp.ProtocolVersion on the server
+const PROTOCOL_VERSION = 2;
+
 type DispatchFunctions = {
     [key: string]: (data: Dispatch) => Dispatch | void;
 };
 
 enum Fun {
     AUTH = "auth",
-    KEY = "key",
     PING = "ping",
     RENDER = "render",
     CLASS = "class",
     CUSTOM = "custom",
     REDIRECT = "redirect",
+    NAVIGATE = "navigate",
     EVENT = "event",
+    ACK = "ack",
     ERROR = "error",
 }
 
@@ -23,15 +27,13 @@ type FnEventListener = {
     id: string;
     target_id: string;
     on: string;
-    action: string;
-    method: string;
-    form_data: string;
     data: Object;
 };
 
 type FnPing = {
     server: boolean;
     client: boolean;
+    sent: number;
 };
 
 type FnRender = {
@@ -60,20 +62,29 @@ type FnCustom = {
 
 type FnRedirect = {
     url: string;
+    // Set by the server when a Router renders url over the connection
+    navigate?: boolean;
+    // Set when the browser has already moved to url
+    pop?: boolean;
 };
 
 type FnError = {
     message: string;
+    code?: string;
+    // Set by the server when the page must be hard reloaded
+    reload?: boolean;
 };
 
 type Dispatch = {
     function: Fun;
     id: string;
+    seq?: number;
     key: string;
     conn_id: string;
     handler_id: string;
     action: string;
     label: string;
+    auth?: FnAuth;
     event: FnEventListener;
     ping: FnPing;
     render: FnRender;
@@ -84,6 +95,7 @@ type Dispatch = {
 };
 
 export {
+    PROTOCOL_VERSION,
     DispatchFunctions,
     Fun,
     FnAuth,
@@ -0,0 +1,26 @@
+import { API } from "./api";
+
+// listenRouter navigates between the routes of the page's Router over the
+// connection instead of loading them, if the page was served by a Router
+export function listenRouter(api: API) {
+    const meta = document.querySelector('meta[name="fncmp-router"]');
+    if (!meta) return;
+    const handlerID = meta.getAttribute("content");
+
+    document.addEventListener("click", (ev: MouseEvent) => {
+        if (ev.defaultPrevented || ev.button !== 0) return;
+        if (ev.metaKey || ev.ctrlKey || ev.shiftKey || ev.altKey) return;
+        const link = (ev.target as Element).closest?.("a[href]") as HTMLAnchorElement | null;
+        if (!link || link.target || link.hasAttribute("download")) return;
+        const url = new URL(link.href, window.location.href);
+        if (url.origin !== window.location.origin) return;
+        // Links within the page scroll as usual
+        if (url.pathname === window.location.pathname && url.search === window.location.search && url.hash) return;
+        ev.preventDefault();
+        api.Navigate(handlerID, url.pathname + url.search, false);
+    });
+
+    window.addEventListener("popstate", () => {
+        api.Navigate(handlerID, window.location.pathname + window.location.search, true);
+    });
+}
@@ -1,18 +1,30 @@
 import { API } from "./api";
-import { Dispatch } from "./fncmp_types";
+import { Dispatch, Fun, PROTOCOL_VERSION } from "./fncmp_types";
+import { PROTOCOLS, encode, decode } from "./codec";
+import { listenRouter } from "./router";
 var did_connect = false;
 let api: API;
 
+// Reconnect attempts before falling back to a full page reload
+const MAX_RECONNECTS = 10;
+
 export class Socket {
     private ws: WebSocket | null = null;
     private addr: string | undefined = undefined;
-    private key: string | undefined = undefined;
+    // Identifies this tab's connection within the session, kept across
+    // reconnects so the session resumes and across reloads so the server
+    // replaces the previous page's state instead of keeping it
+    private tab: string = tabID();
+    // Sequence number of the last dispatch received from the server
+    private seq: number = 0;
+    private reconnects: number = 0;
+    // Set when the address is given rather than taken from the page
+    private fixed: boolean = false;
 
     constructor(addr?: string) {
         if (addr) {
             this.addr = addr;
-        } else {
-            this.init();
+            this.fixed = true;
         }
         this.connect();
     }
@@ -29,53 +41,132 @@ export class Socket {
             path_parsed = "/";
         }
 
-        let key = localStorage.getItem("fncmp");
-        if (!key) {
-            key = "xxxxxxxx-xxxx-4xxx-yxxx-xxxxxxxxxxxx".replace(
-                /[xy]/g,
-                function (c) {
-                    let r = (Math.random() * 16) | 0,
-                        v = c == "x" ? r : (r & 0x3) | 0x8;
-                    return v.toString(16);
-                }
-            );
-            localStorage.setItem("fncmp", key);
-        }
-
         let protocol = "wss"
         if (location.protocol !== 'https:') {
             protocol = "ws"
         }
 
-        this.addr = protocol + "://" + window.location.host + path_parsed + "?fncmp_id=" + this.key;
+        // The signed connection token is sent by the browser as the
+        // HttpOnly cookie set with the page, never in the URL
+        let params = "?fncmp_tab=" + this.tab + "&fncmp_v=" + PROTOCOL_VERSION;
+
+        // The page's path and query, which the handshake's own URL does not
+        // carry, for the server's Request and Query
+        params += "&fncmp_path=" + encodeURIComponent(window.location.pathname + window.location.search);
+
+        // With a dedicated socket endpoint, every handler that rendered the
+        // page shares one connection
+        const socket = document.querySelector('meta[name="fncmp-socket"]');
+        if (socket) {
+            path_parsed = socket.getAttribute("content");
+            document.querySelectorAll('meta[name="fncmp-handler"]').forEach((m) => {
+                params += "&fncmp_handler=" + encodeURIComponent(m.getAttribute("content"));
+            });
+        }
+
+        this.addr = protocol + "://" + window.location.host + path_parsed + params;
+    }
+
+    // url returns the server address, asking to resume the session once
+    // any dispatch has been received
+    private url(): string {
+        if (this.seq == 0) return this.addr;
+        const sep = this.addr.includes("?") ? "&" : "?";
+        return this.addr + sep + "fncmp_seq=" + this.seq;
     }
 
     private connect() {
+        // The page's path may have changed since the last connection by
+        // navigating between the routes of a Router
+        if (!this.fixed) this.init();
         try {
-            this.ws = new WebSocket(this.addr);
+            this.ws = new WebSocket(this.url(), PROTOCOLS);
+            this.ws.binaryType = "arraybuffer";
         } catch (err) {
             throw new Error("ws: failed to connect to fncmp server: " + err);
         }
+        // Registered before the API so auth is the first dispatch sent
+        this.ws.addEventListener("open", () => this.authenticate());
         try {
-            api = new API(this.ws);
+            if (!api) {
+                api = new API(this.ws);
+                listenRouter(api);
+            } else {
+                api.SetSocket(this.ws);
+            }
         } catch (err) {
             throw new Error("ws: failed to initiate API: " + err);
         }
 
-        this.ws.onopen = function () {
+        this.ws.onopen = () => {
             did_connect = true;
+            this.reconnects = 0;
         };
-        this.ws.onclose = function () {
-            setTimeout(() => {
-                if(typeof window !== 'undefined')
-                window.location.reload();
-            }, 1000);
+        this.ws.onclose = () => {
+            this.reconnect();
         };
         this.ws.onerror = function () {};
 
-        this.ws.onmessage = function (event) {
-            let d = JSON.parse(event.data) as Dispatch;
+        this.ws.onmessage = (event) => {
+            let d = decode(event.data);
+            if (d.seq) {
+                // Skip dispatches already applied before a reconnect
+                if (d.seq <= this.seq) return;
+                this.seq = d.seq;
+            }
             api.Process(d);
         };
     }
-}
\ No newline at end of file
+
+    // authenticate sends the token from <meta name="fncmp-token"> or
+    // window.fncmp_token, if any, for the server's Authenticator
+    private authenticate() {
+        const meta = document.querySelector('meta[name="fncmp-token"]');
+        const token = meta?.getAttribute("content") || (window as any).fncmp_token || "";
+        this.ws.send(encode(this.ws, {
+            function: Fun.AUTH,
+            auth: { key: "", token: token },
+        } as Dispatch));
+    }
+
+    // reconnect tries to resume the session with backoff and reloads the
+    // page if the server cannot be reached
+    private reconnect() {
+        if (this.reconnects >= MAX_RECONNECTS) {
+            if (typeof window !== "undefined") window.location.reload();
+            return;
+        }
+        const delay = Math.min(250 * 2 ** this.reconnects, 5000);
+        this.reconnects++;
+        setTimeout(() => this.connect(), delay);
+    }
+}
+
+// tabID returns the ID of this tab, kept in sessionStorage, which is not
+// shared between tabs
+function tabID(): string {
+    try {
+        let id = sessionStorage.getItem("fncmp_tab");
+        // A duplicated tab copies the storage of a page that is still open,
+        // while a reloaded page has marked itself closed
+        if (!id || sessionStorage.getItem("fncmp_tab_open")) {
+            id = newTabID();
+            sessionStorage.setItem("fncmp_tab", id);
+        }
+        sessionStorage.setItem("fncmp_tab_open", "1");
+        window.addEventListener("pagehide", () => sessionStorage.removeItem("fncmp_tab_open"));
+        window.addEventListener("pageshow", () => sessionStorage.setItem("fncmp_tab_open", "1"));
+        return id;
+    } catch {
+        // Storage may be unavailable, e.g. when disabled
+        return newTabID();
+    }
+}
+
+function newTabID(): string {
+    return "xxxxxxxx-xxxx-4xxx-yxxx-xxxxxxxxxxxx".replace(/[xy]/g, function (c) {
+        let r = (Math.random() * 16) | 0,
+            v = c == "x" ? r : (r & 0x3) | 0x8;
+        return v.toString(16);
+    });
+}
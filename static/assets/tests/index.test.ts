@@ -7,32 +7,56 @@ import {
     afterAll,
     expect,
     beforeEach,
+    jest,
 } from "@jest/globals";
 import { JSDOM } from "jsdom";
 import { Dispatch, Fun } from "../fncmp_types";
+import { decode, pack, unpack, withDefaults } from "../codec";
+
+// Wait for a callback to return true
+async function waitCallback(callback: () => boolean) {
+    return new Promise((resolve) => {
+        const check = () => {
+            setTimeout(() => {
+                if (callback()) {
+                    resolve(null);
+                } else {
+                    check();
+                }
+            }, 25);
+        };
+        check();
+    });
+}
+
+// Load a page at url, which the client reads when it connects
+function loadPage(html: string, url: string = "http://localhost/") {
+    const jsdom = new JSDOM(html, { url });
+    global.window = jsdom.window as any;
+    global.document = jsdom.window.document;
+    global.history = jsdom.window.history;
+    global.location = jsdom.window.location;
+    global.sessionStorage = jsdom.window.sessionStorage;
+}
+
+// Load the client again, as a new page does, without the API of the
+// previous Socket
+function freshSocket(): typeof Socket {
+    let fresh: typeof Socket;
+    jest.isolateModules(() => {
+        fresh = require("../socket").Socket;
+    });
+    return fresh;
+}
 
 describe("test websocket functions", () => {
     let dispatches: Dispatch[] = [];
     let server: WS;
     let socket: Socket;
 
-    // Wait for a callback to return true
-    async function waitCallback(callback: () => boolean) {
-        return new Promise((resolve) => {
-            const check = () => {
-                setTimeout(() => {
-                    if (callback()) {
-                        resolve(null);
-                    } else {
-                        check();
-                    }
-                }, 25);
-            };
-            check();
-        });
-    }
-
     beforeAll(async () => {
+        // The client reads the page when it connects
+        loadPage("<!DOCTYPE html><html><body><main></main></body></html>");
         // Create a new websocket server
         server = new WS("ws://localhost:1234", { jsonProtocol: true });
         server.on("connection", (socket) => {
@@ -193,3 +217,320 @@ describe("test websocket functions", () => {
         WS.clean();
     });
 });
+
+describe("test router", () => {
+    let dispatches: Dispatch[] = [];
+    let server: WS;
+
+    beforeAll(async () => {
+        loadPage(
+            `<!DOCTYPE html><html><head><meta name="fncmp-router" content="router"></head><body>
+            <a id="route" href="/posts/1?page=2">post</a>
+            <a id="hash" href="#comments">comments</a>
+            <a id="external" href="https://example.com/posts/1">post</a>
+            <a id="blank" href="/posts/1" target="_blank">post</a>
+            <a id="download" href="/posts/1" download>post</a>
+            </body></html>`,
+            "http://localhost/posts"
+        );
+        server = new WS("ws://localhost:1235", { jsonProtocol: true });
+        server.on("connection", (socket) => {
+            socket.on("message", (message) => {
+                dispatches.push(JSON.parse(message.toString()));
+            });
+        });
+        new (freshSocket())("ws://localhost:1235");
+        await server.connected;
+    });
+
+    beforeEach(async () => {
+        await new Promise((resolve) => setTimeout(resolve, 100));
+        dispatches = [];
+    });
+
+    const navigations = () => dispatches.filter((d) => d.function === Fun.NAVIGATE);
+
+    const test_cases = [
+        {
+            name: "test navigate on click",
+            id: "route",
+            init: {},
+            url: "/posts/1?page=2",
+        },
+        {
+            name: "test modified click",
+            id: "route",
+            init: { ctrlKey: true },
+            url: "",
+        },
+        {
+            name: "test other button",
+            id: "route",
+            init: { button: 1 },
+            url: "",
+        },
+        {
+            name: "test link within page",
+            id: "hash",
+            init: {},
+            url: "",
+        },
+        {
+            name: "test link to other origin",
+            id: "external",
+            init: {},
+            url: "",
+        },
+        {
+            name: "test link with target",
+            id: "blank",
+            init: {},
+            url: "",
+        },
+        {
+            name: "test download link",
+            id: "download",
+            init: {},
+            url: "",
+        },
+    ];
+
+    test_cases.forEach((test_case) => {
+        test(test_case.name, async () => {
+            const click = new window.MouseEvent("click", {
+                bubbles: true,
+                cancelable: true,
+                button: 0,
+                ...test_case.init,
+            });
+            document.getElementById(test_case.id).dispatchEvent(click);
+            // Links the router does not handle are followed by the browser
+            expect(click.defaultPrevented).toEqual(test_case.url !== "");
+
+            if (test_case.url === "") {
+                await new Promise((resolve) => setTimeout(resolve, 100));
+                expect(navigations().length).toEqual(0);
+                return;
+            }
+            await waitCallback(() => navigations().length > 0);
+            expect(navigations()[0].handler_id).toEqual("router");
+            expect(navigations()[0].redirect).toEqual({ url: test_case.url, pop: false });
+        });
+    });
+
+    test("test navigate on popstate", async () => {
+        // The browser has moved to the URL on back or forward
+        window.history.pushState(null, "", "/posts/2?page=3");
+        window.dispatchEvent(new window.PopStateEvent("popstate"));
+        await waitCallback(() => navigations().length > 0);
+        expect(navigations()[0].handler_id).toEqual("router");
+        expect(navigations()[0].redirect).toEqual({ url: "/posts/2?page=3", pop: true });
+    });
+
+    afterAll(() => {
+        WS.clean();
+    });
+});
+
+describe("test session resumption", () => {
+    const url = "ws://localhost:1236";
+    let dispatches: Dispatch[] = [];
+    let server: WS;
+
+    // Start a server at url recording the dispatches it receives
+    function listen(): WS {
+        const ws = new WS(url, { jsonProtocol: true });
+        ws.on("connection", (socket) => {
+            socket.on("message", (message) => {
+                dispatches.push(JSON.parse(message.toString()));
+            });
+        });
+        return ws;
+    }
+
+    const html = () => document.querySelector("main").innerHTML;
+
+    const render = (seq: number) => ({
+        function: Fun.RENDER,
+        seq: seq,
+        render: {
+            tag: "main",
+            html: `<p>${seq}</p>`,
+            append: true,
+        },
+    });
+
+    beforeAll(async () => {
+        loadPage("<!DOCTYPE html><html><body><main></main></body></html>");
+        server = listen();
+        new (freshSocket())(url);
+        await server.connected;
+    });
+
+    test("test skip applied dispatches", async () => {
+        server.send(render(1));
+        server.send(render(2));
+        await waitCallback(() => html().endsWith("<p>2</p>"));
+        // Dispatches up to the last one received are not applied again
+        server.send(render(1));
+        server.send(render(3));
+        await waitCallback(() => html().endsWith("<p>3</p>"));
+        expect(html()).toEqual("<p>1</p><p>2</p><p>3</p>");
+    });
+
+    test("test resume after reconnect", async () => {
+        const closed = Date.now();
+        server.close();
+        // Attempts 250ms and 750ms after closing find no server, so the
+        // next one waits another second
+        await new Promise((resolve) => setTimeout(resolve, 1000));
+        server = listen();
+        let connected = 0;
+        server.on("connection", () => {
+            connected = Date.now();
+        });
+        const client = await server.connected;
+        expect(connected - closed).toBeGreaterThanOrEqual(1500);
+        expect(new URL(client.url).searchParams.get("fncmp_seq")).toEqual("3");
+
+        // The server resumes after the last dispatch the client received
+        server.send(render(4));
+        await waitCallback(() => html().endsWith("<p>4</p>"));
+        expect(html()).toEqual("<p>1</p><p>2</p><p>3</p><p>4</p>");
+    });
+
+    test("test acknowledge dispatches", async () => {
+        dispatches = [];
+        const acks = () => dispatches.filter((d) => d.function === Fun.ACK);
+        server.send({ ...render(5), conn_id: "conn", handler_id: "handler" });
+        server.send({
+            function: Fun.RENDER,
+            render: { tag: "main", html: "<p>unsequenced</p>", append: true },
+        });
+        server.send(render(6));
+        server.send(render(5));
+        await waitCallback(() => acks().length >= 2);
+        await new Promise((resolve) => setTimeout(resolve, 100));
+        // Only sequenced dispatches are acknowledged, once each
+        expect(acks()).toEqual([
+            { function: Fun.ACK, seq: 5, conn_id: "conn", handler_id: "handler" },
+            { function: Fun.ACK, seq: 6, conn_id: "", handler_id: "" },
+        ]);
+    });
+
+    afterAll(() => {
+        WS.clean();
+    });
+});
+
+describe("test tabs", () => {
+    let urls: string[] = [];
+    let server: WS;
+
+    beforeAll(() => {
+        loadPage(
+            "<!DOCTYPE html><html><body><main></main></body></html>",
+            "http://localhost:1237/page"
+        );
+        server = new WS("ws://localhost:1237/page", { jsonProtocol: true });
+        server.on("connection", (socket) => {
+            urls.push(socket.url);
+        });
+    });
+
+    // Load the page's client again and return the tab it connected with
+    async function open(): Promise<string> {
+        const n = urls.length;
+        new (freshSocket())();
+        await waitCallback(() => urls.length > n);
+        return new URL(urls[n]).searchParams.get("fncmp_tab");
+    }
+
+    test("test tab kept across reloads", async () => {
+        const tab = await open();
+        expect(tab).toBeTruthy();
+        expect(sessionStorage.getItem("fncmp_tab")).toEqual(tab);
+        window.dispatchEvent(new window.Event("pagehide"));
+        expect(await open()).toEqual(tab);
+    });
+
+    test("test duplicated tab", async () => {
+        // A duplicated tab copies the storage of a page that is still open
+        const tab = sessionStorage.getItem("fncmp_tab");
+        expect(sessionStorage.getItem("fncmp_tab_open")).toEqual("1");
+        const duplicate = await open();
+        expect(duplicate).not.toEqual(tab);
+        expect(sessionStorage.getItem("fncmp_tab")).toEqual(duplicate);
+    });
+
+    test("test storage unavailable", async () => {
+        const storage = sessionStorage;
+        global.sessionStorage = undefined;
+        try {
+            expect(await open()).toMatch(/^[0-9a-f-]{36}$/);
+        } finally {
+            global.sessionStorage = storage;
+        }
+    });
+
+    afterAll(() => {
+        WS.clean();
+    });
+});
+
+describe("test codec", () => {
+    const dispatch = {
+        function: Fun.RENDER,
+        id: "id",
+        seq: 2 ** 40,
+        conn_id: "conn",
+        handler_id: "handler",
+        label: "ünïcödé ✓",
+        render: {
+            tag: "main",
+            inner: true,
+            html: "<p>" + "x".repeat(300) + "</p>",
+            event_listeners: [
+                {
+                    id: "listener",
+                    target_id: "target",
+                    on: "click",
+                    data: { small: -5, int: -100000, float: 1.5, list: [null, true, false, ""] },
+                },
+            ],
+        },
+    };
+
+    test("test msgpack round trip", () => {
+        expect(unpack(pack(dispatch))).toEqual(dispatch);
+    });
+
+    test("test decode msgpack", () => {
+        const d = decode(pack(dispatch).buffer as ArrayBuffer);
+        expect(d).toEqual(withDefaults(JSON.parse(JSON.stringify(dispatch))));
+        expect(d.render.outer).toEqual(false);
+        expect(d.render.event_listeners[0].data).toEqual(dispatch.render.event_listeners[0].data);
+    });
+
+    test("test decode server frame", () => {
+        // A ping as encoded by the server, which leaves out zero values
+        const frame = new Uint8Array([
+            0x86, 0xa2, 0x69, 0x64, 0xa2, 0x69, 0x64, 0xa3, 0x73, 0x65, 0x71, 0x07,
+            0xa7, 0x63, 0x6f, 0x6e, 0x6e, 0x5f, 0x69, 0x64, 0xa4, 0x63, 0x6f, 0x6e,
+            0x6e, 0xaa, 0x68, 0x61, 0x6e, 0x64, 0x6c, 0x65, 0x72, 0x5f, 0x69, 0x64,
+            0xa7, 0x68, 0x61, 0x6e, 0x64, 0x6c, 0x65, 0x72, 0xa8, 0x66, 0x75, 0x6e,
+            0x63, 0x74, 0x69, 0x6f, 0x6e, 0xa4, 0x70, 0x69, 0x6e, 0x67, 0xa4, 0x70,
+            0x69, 0x6e, 0x67, 0x82, 0xa6, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0xc3,
+            0xa4, 0x73, 0x65, 0x6e, 0x74, 0xd3, 0x00, 0x00, 0x01, 0x8b, 0xcf, 0xe5,
+            0x68, 0x00,
+        ]);
+        const d = decode(frame.buffer as ArrayBuffer);
+        expect(d.function).toEqual(Fun.PING);
+        expect(d.seq).toEqual(7);
+        expect(d.conn_id).toEqual("conn");
+        expect(d.key).toEqual("");
+        expect(d.ping).toEqual({ server: true, client: false, sent: 1700000000000 });
+        expect(d.render.event_listeners).toEqual([]);
+        expect(d.redirect.url).toEqual("");
+    });
+});
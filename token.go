@@ -0,0 +1,113 @@
+package fncmp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// connTokenCookie is the HttpOnly cookie carrying the connection token, sent
+// by the browser with the WebSocket handshake
+const connTokenCookie = "fncmp_token"
+
+// SigningKey signs and verifies connection tokens.
+//
+// ID is carried in each token so the key that signed it can be found after
+// rotation. It must not contain a '.'.
+type SigningKey struct {
+	ID     string
+	Secret []byte
+}
+
+// newSigningKey returns a random key for a single process
+func newSigningKey() SigningKey {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return SigningKey{ID: "local", Secret: secret}
+}
+
+// signConnToken returns a token for connection id that expires at expires,
+// signed with the first of Config.SigningKeys.
+//
+// The token has the form "<id>.<expiry>.<key ID>.<signature>".
+func (a *App) signConnToken(id string, expires time.Time) string {
+	key := a.config.SigningKeys[0]
+	payload := id + "." + strconv.FormatInt(expires.Unix(), 10) + "." + key.ID
+	return payload + "." + sign(key, payload)
+}
+
+// verifyConnToken returns the connection ID of token if it was signed with
+// any of Config.SigningKeys and has not expired
+func (a *App) verifyConnToken(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 || parts[0] == "" {
+		return "", ErrInvalidToken
+	}
+	id, expiry, keyID, sig := parts[0], parts[1], parts[2], parts[3]
+	for _, key := range a.config.SigningKeys {
+		if key.ID != keyID {
+			continue
+		}
+		payload := id + "." + expiry + "." + keyID
+		if !hmac.Equal([]byte(sig), []byte(sign(key, payload))) {
+			return "", ErrInvalidToken
+		}
+		unix, err := strconv.ParseInt(expiry, 10, 64)
+		if err != nil {
+			return "", ErrInvalidToken
+		}
+		if time.Now().After(time.Unix(unix, 0)) {
+			return "", ErrTokenExpired
+		}
+		return id, nil
+	}
+	return "", fmt.Errorf("%w: unknown key '%s'", ErrInvalidToken, keyID)
+}
+
+func sign(key SigningKey, payload string) string {
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// issueConnToken sets the connection token cookie for the page being
+// rendered, keeping the connection ID of a valid token the client already
+// holds so its state survives the page load
+func (a *App) issueConnToken(w http.ResponseWriter, r *http.Request) {
+	var id string
+	if cookie, err := r.Cookie(connTokenCookie); err == nil {
+		id, _ = a.verifyConnToken(cookie.Value)
+	}
+	if id == "" {
+		id = uuid.New().String()
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     connTokenCookie,
+		Value:    a.signConnToken(id, time.Now().Add(a.config.ConnTokenTTL)),
+		Path:     "/",
+		MaxAge:   int(a.config.ConnTokenTTL.Seconds()),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// connToken returns the connection token of a handshake: the cookie set with
+// the page or, for clients other than browsers, the fncmp_id query parameter,
+// which pageRequest hides from HandleFns
+func connToken(r *http.Request) string {
+	if cookie, err := r.Cookie(connTokenCookie); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	return r.URL.Query().Get("fncmp_id")
+}
@@ -0,0 +1,115 @@
+package fncmp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestConnToken(t *testing.T) {
+	keys := defaultApp.config.SigningKeys
+	defer func() { defaultApp.config.SigningKeys = keys }()
+
+	old := SigningKey{ID: "old", Secret: []byte("old secret")}
+	current := SigningKey{ID: "new", Secret: []byte("new secret")}
+
+	defaultApp.config.SigningKeys = []SigningKey{old}
+	oldToken := defaultApp.signConnToken("id", time.Now().Add(time.Hour))
+	defaultApp.config.SigningKeys = []SigningKey{current, old}
+	token := defaultApp.signConnToken("id", time.Now().Add(time.Hour))
+
+	cases := []struct {
+		name  string
+		token string
+		keys  []SigningKey
+		err   error
+	}{
+		{"valid", token, []SigningKey{current, old}, nil},
+		{"rotated key still verifies", oldToken, []SigningKey{current, old}, nil},
+		{"retired key", oldToken, []SigningKey{current}, ErrInvalidToken},
+		{"expired", defaultApp.signConnToken("id", time.Now().Add(-time.Second)), []SigningKey{current}, ErrTokenExpired},
+		{"forged id", "other" + token[2:], []SigningKey{current}, ErrInvalidToken},
+		{"raw id", "id", []SigningKey{current}, ErrInvalidToken},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defaultApp.config.SigningKeys = c.keys
+			id, err := defaultApp.verifyConnToken(c.token)
+			if !errors.Is(err, c.err) {
+				t.Fatalf("expected %v, got %v", c.err, err)
+			}
+			if err == nil && id != "id" {
+				t.Errorf("expected id, got %s", id)
+			}
+		})
+	}
+}
+
+func TestIssueConnToken(t *testing.T) {
+	w := httptest.NewRecorder()
+	defaultApp.issueConnToken(w, httptest.NewRequest("GET", "/", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != connTokenCookie {
+		t.Fatalf("expected %s cookie, got %v", connTokenCookie, cookies)
+	}
+	if !cookies[0].HttpOnly {
+		t.Error("expected HttpOnly cookie")
+	}
+	id, err := defaultApp.verifyConnToken(cookies[0].Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A valid token keeps its connection ID
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	defaultApp.issueConnToken(w, r)
+	if got, _ := defaultApp.verifyConnToken(w.Result().Cookies()[0].Value); got != id {
+		t.Errorf("expected %s, got %s", id, got)
+	}
+}
+
+func TestConnTokenCookie(t *testing.T) {
+	a := NewApp(&Config{Silent: true})
+	server := httptest.NewServer(a.MiddleWareFn(
+		func(w http.ResponseWriter, r *http.Request) {},
+		func(ctx context.Context) FnComponent {
+			return NewFn(ctx, HTML("<p>"+Query(ctx).Encode()+"</p>"))
+		},
+	))
+	defer server.Close()
+	res, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	cookies := res.Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected token cookie, got %v", cookies)
+	}
+	id, _ := a.verifyConnToken(cookies[0].Value)
+
+	// Browsers send the cookie with the handshake instead of the token
+	u := "ws" + strings.TrimPrefix(server.URL, "http") + "/?fncmp_v=" + strconv.Itoa(ProtocolVersion) + "&fncmp_path=%2F%3Fq%3D1"
+	ws := _test_dial(t, u, http.Header{"Cookie": {cookies[0].String()}})
+	if d := _test_read(t, ws, render); !strings.Contains(d.FnRender.HTML, "<p>q=1</p>") {
+		t.Errorf("expected page query, got %s", d.FnRender.HTML)
+	}
+	if _, ok := a.Connection(id); !ok {
+		t.Errorf("expected connection %s", id)
+	}
+
+	// Handshakes without a token are refused
+	if _, res, err := websocket.DefaultDialer.Dial(u, nil); err == nil || res == nil || res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected %d, got %v", http.StatusForbidden, err)
+	}
+}
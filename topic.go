@@ -0,0 +1,148 @@
+package fncmp
+
+import (
+	"context"
+	"sync"
+)
+
+// topicPool maps topic names to the IDs of subscribed connections
+type topicPool struct {
+	mu   sync.Mutex
+	subs map[string]map[string]struct{}
+}
+
+func (t *topicPool) Add(topic string, connID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.subs[topic]; !ok {
+		t.subs[topic] = make(map[string]struct{})
+	}
+	t.subs[topic][connID] = struct{}{}
+}
+
+// Remove unsubscribes connID from topic and reports whether the topic has no
+// subscribers left
+func (t *topicPool) Remove(topic string, connID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.subs[topic], connID)
+	if len(t.subs[topic]) == 0 {
+		delete(t.subs, topic)
+		return true
+	}
+	return false
+}
+
+// Get returns the IDs of connections subscribed to topic
+func (t *topicPool) Get(topic string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ids := make([]string, 0, len(t.subs[topic]))
+	for id := range t.subs[topic] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Delete unsubscribes connID from every topic and returns the topics left
+// without subscribers
+func (t *topicPool) Delete(connID string) (empty []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for topic, subs := range t.subs {
+		delete(subs, connID)
+		if len(subs) == 0 {
+			delete(t.subs, topic)
+			empty = append(empty, topic)
+		}
+	}
+	return empty
+}
+
+// deleteConn unsubscribes connID from every topic, including on the backplane
+// for topics left without local subscribers
+func (a *App) deleteConn(connID string) {
+	for _, topic := range a.topics.Delete(connID) {
+		a.remote.Unsubscribe(topicChannel(topic))
+	}
+}
+
+// Subscribe subscribes the connection in ctx to topic
+//
+// The subscription lasts until Unsubscribe is called or the connection's
+// state expires.
+func Subscribe(ctx context.Context, topic string) error {
+	dd, ok := dispatchFromContext(ctx)
+	if !ok {
+		return ErrCtxMissingDispatch
+	}
+	a := dd.app()
+	a.topics.Add(topic, dd.ConnID)
+	a.subscribeTopic(topic)
+	return nil
+}
+
+// Unsubscribe unsubscribes the connection in ctx from topic
+func Unsubscribe(ctx context.Context, topic string) error {
+	dd, ok := dispatchFromContext(ctx)
+	if !ok {
+		return ErrCtxMissingDispatch
+	}
+	if a := dd.app(); a.topics.Remove(topic, dd.ConnID) {
+		a.remote.Unsubscribe(topicChannel(topic))
+	}
+	return nil
+}
+
+// Subscribers returns the number of connections of the default App
+// subscribed to topic
+func Subscribers(topic string) int {
+	return defaultApp.Subscribers(topic)
+}
+
+// Subscribers returns the number of connections subscribed to topic
+func (a *App) Subscribers(topic string) int {
+	return len(a.topics.Get(topic))
+}
+
+// Publish dispatches to subscribers of topic with the default App. See
+// App.Publish.
+func Publish(topic string, h HandleFn) int {
+	return defaultApp.Publish(topic, h)
+}
+
+// Publish calls h with the context of every connected subscriber of topic in
+// this process and dispatches the returned FnComponent to that subscriber.
+// See Broadcast to reach subscribers in other processes.
+//
+// Each subscriber gets its own FnComponent, so event listeners are registered
+// against its own connection. h may also dispatch directly, e.g. with
+// AddClasses or JS, and return an empty FnComponent.
+//
+// h is wrapped in the middleware of the subscriber's primary handler, and a
+// panic in h is recovered like one in an event callback.
+//
+// Publish returns the number of subscribers dispatched to.
+func (a *App) Publish(topic string, hf HandleFn) int {
+	sent := 0
+	for _, id := range a.topics.Get(topic) {
+		c, ok := a.connPool.Get(id)
+		if !ok {
+			continue
+		}
+		h, ok := a.handlers.Get(c.HandlerID)
+		if !ok {
+			continue
+		}
+		fn, ok := h.call(c.context(), h.wrap(hf), "publish "+topic, "")
+		if !ok {
+			continue
+		}
+		fn.dispatch.conn = c
+		fn.dispatch.ConnID = c.ID
+		fn.dispatch.HandlerID = c.HandlerID
+		fn.Dispatch()
+		sent++
+	}
+	return sent
+}
@@ -0,0 +1,106 @@
+package fncmp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestPublish(t *testing.T) {
+	topic := t.Name()
+	server := _test_server(func(ctx context.Context) FnComponent {
+		if err := Subscribe(ctx, topic); err != nil {
+			t.Error(err)
+		}
+		return NewFn(ctx, HTML("<p>test</p>"))
+	})
+	defer server.Close()
+
+	var clients []*websocket.Conn
+	for i := 0; i < 3; i++ {
+		ws, _, err := websocket.DefaultDialer.Dial(_test_url(defaultApp, server, "/", fmt.Sprintf("%s_%d", t.Name(), i)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+		_test_read(t, ws, render)
+		clients = append(clients, ws)
+	}
+
+	if n := Subscribers(topic); n != len(clients) {
+		t.Fatalf("expected %d subscribers, got %d", len(clients), n)
+	}
+
+	n := Publish(topic, func(ctx context.Context) FnComponent {
+		id, _ := ctx.Value(ConnIDKey).(string)
+		return NewFn(ctx, HTML(id)).WithEvents(nil, OnClick)
+	})
+	if n != len(clients) {
+		t.Errorf("expected %d recipients, got %d", len(clients), n)
+	}
+
+	for i, ws := range clients {
+		d := _test_read(t, ws, render)
+		id := fmt.Sprintf("%s_%d", t.Name(), i)
+		if d.ConnID != id {
+			t.Errorf("expected dispatch for %s, got %s", id, d.ConnID)
+		}
+		for _, el := range d.FnRender.EventListeners {
+			if _, ok := defaultApp.evtListeners.Get(el.ID, &conn{ID: id}); !ok {
+				t.Errorf("expected listener %s registered for %s", el.ID, id)
+			}
+		}
+	}
+}
+
+func TestPublishMiddleware(t *testing.T) {
+	type key struct{}
+	topic := t.Name()
+	a := NewApp(&Config{Silent: true, Fallback: func(ctx context.Context, err error) FnComponent {
+		return NewFn(ctx, HTML("<p>fallback</p>"))
+	}})
+	a.Use(func(next HandleFn) HandleFn {
+		return func(ctx context.Context) FnComponent {
+			return next(context.WithValue(ctx, key{}, "wrapped"))
+		}
+	})
+	server := httptest.NewServer(a.MiddleWareFn(
+		func(w http.ResponseWriter, r *http.Request) {},
+		func(ctx context.Context) FnComponent {
+			if err := Subscribe(ctx, topic); err != nil {
+				t.Error(err)
+			}
+			return NewFn(ctx, HTML("<p>test</p>"))
+		},
+	))
+	defer server.Close()
+	ws := _test_dial(t, _test_url(a, server, "/", t.Name()), nil)
+	_test_read(t, ws, render)
+
+	cases := []struct {
+		name string
+		hf   HandleFn
+		exp  string
+	}{
+		{"middleware", func(ctx context.Context) FnComponent {
+			v, _ := ctx.Value(key{}).(string)
+			return NewFn(ctx, HTML("<p>"+v+"</p>"))
+		}, "<p>wrapped</p>"},
+		{"panic", func(ctx context.Context) FnComponent { panic("boom") }, "<p>fallback</p>"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if n := a.Publish(topic, c.hf); n != 1 {
+				t.Fatalf("expected 1 recipient, got %d", n)
+			}
+			if d := _test_read(t, ws, render); !strings.Contains(d.FnRender.HTML, c.exp) {
+				t.Errorf("expected %s, got %s", c.exp, d.FnRender.HTML)
+			}
+		})
+	}
+}
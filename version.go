@@ -0,0 +1,117 @@
+package fncmp
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// ProtocolVersion is the version of the protocol between the server and the
+// client bundle, sent by the client as the fncmp_v query parameter.
+//
+//   - 1: the original client. It sends no version, an unsigned connection ID
+//     and no auth dispatch, never acknowledges dispatches and understands
+//     only the original dispatch functions.
+//   - 2: signed connection tokens, sequenced dispatches with
+//     acknowledgements, tabs, codecs, version negotiation and structured
+//     error dispatches.
+//
+// Clients of the previous version are still served through a shim: their
+// unsigned ID is ignored for the session of the connection token cookie set
+// with the page, their dispatches are neither sequenced nor replayed, so
+// OnAck functions are not called, and they are told to reload with a
+// redirect to their page. Clients of other versions are sent a structured
+// error dispatch telling them to hard reload, so they load the current
+// bundle, and are disconnected.
+const ProtocolVersion = 2
+
+// legacyProtocolVersion is the version of clients that send none, served
+// through the shim described at ProtocolVersion
+const legacyProtocolVersion = ProtocolVersion - 1
+
+// Error codes of structured error dispatches sent to the client
+const (
+	errCodeProtocolVersion = "protocol_version"
+	errCodeSessionExpired  = "session_expired"
+)
+
+// clientVersion returns the protocol version of the client that sent r
+func clientVersion(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("fncmp_v")
+	if v == "" {
+		return legacyProtocolVersion, nil
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%w: '%s'", ErrProtocolVersion, v)
+	}
+	if version < legacyProtocolVersion || version > ProtocolVersion {
+		return version, fmt.Errorf("%w: %d, supported %d to %d", ErrProtocolVersion, version, legacyProtocolVersion, ProtocolVersion)
+	}
+	return version, nil
+}
+
+// legacySession returns the session of a client of the previous version. The
+// ID it sends is not signed, so the session is that of the connection token
+// cookie set with the page, or a new one.
+func (a *App) legacySession(r *http.Request) string {
+	if cookie, err := r.Cookie(connTokenCookie); err == nil {
+		if id, err := a.verifyConnToken(cookie.Value); err == nil {
+			return id
+		}
+	}
+	return uuid.New().String()
+}
+
+// reloadDispatch returns a dispatch telling the client to hard reload the
+// page, as an error with code for clients that understand structured errors
+func (c *conn) reloadDispatch(code string, message string) Dispatch {
+	d := Dispatch{
+		ConnID:    c.ID,
+		HandlerID: c.HandlerID,
+		conn:      c,
+	}
+	if c.version == legacyProtocolVersion {
+		// Loading the page again reloads it with the current bundle
+		d.Function = redirect
+		d.FnRedirect.URL = c.page().URL.Path
+		return d
+	}
+	d.Function = _error
+	d.FnError = FnError{Message: message, Code: code, Reload: true}
+	return d
+}
+
+// rejectVersion upgrades the request of a client of an unsupported version
+// to tell it to reload before closing the connection. It runs before the
+// connection token is verified, as such clients may send none.
+func (a *App) rejectVersion(w http.ResponseWriter, r *http.Request, version int) {
+	if !a.checkOrigin(r) {
+		http.Error(w, ErrOriginNotAllowed.Error(), http.StatusForbidden)
+		return
+	}
+	upgrader := websocket.Upgrader{
+		CheckOrigin:  a.checkOrigin,
+		Subprotocols: a.subprotocols(),
+	}
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &conn{
+		app:       a,
+		websocket: ws,
+		request:   r,
+		codec:     a.negotiateCodec(ws.Subprotocol()),
+		version:   version,
+	}
+	d := c.reloadDispatch(errCodeProtocolVersion, fmt.Sprintf("%s: %d, supported %d", ErrProtocolVersion, version, ProtocolVersion))
+	b, err := c.codec.Marshal(d)
+	if err == nil {
+		ws.WriteMessage(c.codec.MessageType(), b)
+	}
+	c.reject(ErrProtocolVersion.Error())
+}
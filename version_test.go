@@ -0,0 +1,123 @@
+package fncmp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestClientVersion(t *testing.T) {
+	cases := []struct {
+		name  string
+		query string
+		exp   int
+		err   error
+	}{
+		{"no version", "", legacyProtocolVersion, nil},
+		{"current", "fncmp_v=" + strconv.Itoa(ProtocolVersion), ProtocolVersion, nil},
+		{"previous", "fncmp_v=" + strconv.Itoa(ProtocolVersion-1), ProtocolVersion - 1, nil},
+		{"too new", "fncmp_v=" + strconv.Itoa(ProtocolVersion+1), ProtocolVersion + 1, ErrProtocolVersion},
+		{"too old", "fncmp_v=0", 0, ErrProtocolVersion},
+		{"invalid", "fncmp_v=x", 0, ErrProtocolVersion},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			v, err := clientVersion(httptest.NewRequest("GET", "/?"+c.query, nil))
+			if !errors.Is(err, c.err) {
+				t.Fatalf("expected %v, got %v", c.err, err)
+			}
+			if v != c.exp {
+				t.Errorf("expected %d, got %d", c.exp, v)
+			}
+		})
+	}
+}
+
+func TestRejectVersion(t *testing.T) {
+	server := _test_server(func(ctx context.Context) FnComponent {
+		return NewFn(ctx, HTML("<p>test</p>"))
+	})
+	defer server.Close()
+
+	cases := []struct {
+		name  string
+		url   string
+		fn    functionName
+		check func(d Dispatch) bool
+	}{
+		{
+			name: "too new",
+			url:  _test_url(defaultApp, server, "/?fncmp_v="+strconv.Itoa(ProtocolVersion+1), t.Name()),
+			fn:   _error,
+			check: func(d Dispatch) bool {
+				return d.FnError.Reload && d.FnError.Code == errCodeProtocolVersion
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ws := _test_dial(t, c.url, nil)
+			if d := _test_read(t, ws, c.fn); !c.check(d) {
+				t.Errorf("expected reload, got %+v", d)
+			}
+			if _, _, err := ws.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+				t.Errorf("expected policy violation, got %v", err)
+			}
+			if _, ok := defaultApp.connPool.Get(t.Name()); ok {
+				t.Error("expected connection not to be pooled")
+			}
+		})
+	}
+}
+
+func TestLegacyClient(t *testing.T) {
+	a := NewApp(&Config{Silent: true})
+	server := httptest.NewServer(a.MiddleWareFn(
+		func(w http.ResponseWriter, r *http.Request) {},
+		func(ctx context.Context) FnComponent {
+			return NewFn(ctx, HTML("<p>test</p>"))
+		},
+	))
+	defer server.Close()
+	res, err := http.Get(server.URL + "/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	cookie := res.Cookies()[0]
+	session, _ := a.verifyConnToken(cookie.Value)
+
+	cases := []struct {
+		name   string
+		header http.Header
+		check  func(id string) bool
+	}{
+		{"cookie", http.Header{"Cookie": {cookie.String()}}, func(id string) bool { return id == session }},
+		{"no cookie", nil, func(id string) bool { return id != "undefined" && id != session }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// The original client sends an unsigned ID and no version
+			ws := _test_dial(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/page?fncmp_id=undefined", c.header)
+			d := _test_read(t, ws, render)
+			if !strings.Contains(d.FnRender.HTML, "<p>test</p>") || d.Seq != 0 {
+				t.Errorf("expected unsequenced render, got %+v", d)
+			}
+			if !c.check(d.ConnID) {
+				t.Errorf("unexpected connection %s", d.ConnID)
+			}
+			if conn, ok := a.connPool.Get(d.ConnID); !ok || conn.version != legacyProtocolVersion {
+				t.Error("expected pooled legacy connection")
+			}
+			if o, ok := a.outboxes.Get(d.ConnID); ok && len(o.entries) > 0 {
+				t.Errorf("expected no recorded dispatches, got %d", len(o.entries))
+			}
+		})
+	}
+}